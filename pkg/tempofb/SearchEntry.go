@@ -85,8 +85,28 @@ func (rcv *SearchEntry) MutateEndTimeUnixNano(n uint64) bool {
 	return rcv._tab.MutateUint64Slot(10, n)
 }
 
+func (rcv *SearchEntry) OriginalTags(obj *KeyValues, j int) bool {
+	o := flatbuffers.UOffsetT(rcv._tab.Offset(12))
+	if o != 0 {
+		x := rcv._tab.Vector(o)
+		x += flatbuffers.UOffsetT(j) * 4
+		x = rcv._tab.Indirect(x)
+		obj.Init(rcv._tab.Bytes, x)
+		return true
+	}
+	return false
+}
+
+func (rcv *SearchEntry) OriginalTagsLength() int {
+	o := flatbuffers.UOffsetT(rcv._tab.Offset(12))
+	if o != 0 {
+		return rcv._tab.VectorLen(o)
+	}
+	return 0
+}
+
 func SearchEntryStart(builder *flatbuffers.Builder) {
-	builder.StartObject(4)
+	builder.StartObject(5)
 }
 func SearchEntryAddId(builder *flatbuffers.Builder, id flatbuffers.UOffsetT) {
 	builder.PrependUOffsetTSlot(0, flatbuffers.UOffsetT(id), 0)
@@ -103,6 +123,12 @@ func SearchEntryAddStartTimeUnixNano(builder *flatbuffers.Builder, startTimeUnix
 func SearchEntryAddEndTimeUnixNano(builder *flatbuffers.Builder, endTimeUnixNano uint64) {
 	builder.PrependUint64Slot(3, endTimeUnixNano, 0)
 }
+func SearchEntryAddOriginalTags(builder *flatbuffers.Builder, originalTags flatbuffers.UOffsetT) {
+	builder.PrependUOffsetTSlot(4, flatbuffers.UOffsetT(originalTags), 0)
+}
+func SearchEntryStartOriginalTagsVector(builder *flatbuffers.Builder, numElems int) flatbuffers.UOffsetT {
+	return builder.StartVector(4, numElems, 4)
+}
 func SearchEntryEnd(builder *flatbuffers.Builder) flatbuffers.UOffsetT {
 	return builder.EndObject()
 }
@@ -1,5 +1,477 @@
 package tempofb
 
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	flatbuffers "github.com/google/flatbuffers/go"
+	"github.com/grafana/tempo/tempodb/encoding/common"
+)
+
+// NewSearchPageFromBytesSafe is like GetRootAsSearchPage but returns an error instead of panicking
+// on malformed data. The whole page is read once to check that all offsets are within bounds.
+func NewSearchPageFromBytesSafe(b []byte) (*SearchPage, error) {
+	page, err := newSearchPageHeaderSafe(b)
+	if err != nil {
+		return nil, err
+	}
+	if err := validateEntries(page); err != nil {
+		return nil, err
+	}
+	return page, nil
+}
+
+// newSearchPageHeaderSafe is like NewSearchPageFromBytesSafe but doesn't read the entries, so that
+// the page-level tags can be checked before validating the entries with validateEntries.
+func newSearchPageHeaderSafe(b []byte) (_ *SearchPage, err error) {
+	page, err := newSearchPageRootSafe(b)
+	if err != nil {
+		return nil, err
+	}
+
+	defer recoverMalformed(&err, "decoding search page")
+
+	readTags(page, &KeyValues{})
+	return page, nil
+}
+
+// validateEntries reads all entries of the page to check that they are within bounds.
+func validateEntries(page *SearchPage) (err error) {
+	defer recoverMalformed(&err, "decoding search page")
+
+	kv := &KeyValues{}
+	e := &SearchEntry{}
+	for i, l := 0, page.EntriesLength(); i < l; i++ {
+		page.Entries(e, i)
+		readEntry(e, kv)
+	}
+	return nil
+}
+
+// newSearchPageRootSafe is like NewSearchPageFromBytesSafe but only checks the root table and that the
+// entries vector is within bounds. Neither the page-level tags nor the entries are read, callers must
+// still recover from malformed entries, see recoverMalformed.
+func newSearchPageRootSafe(b []byte) (_ *SearchPage, err error) {
+	defer recoverMalformed(&err, "decoding search page")
+
+	if len(b) < flatbuffers.SizeUOffsetT {
+		return nil, fmt.Errorf("error decoding search page: too short: %d bytes", len(b))
+	}
+
+	page := GetRootAsSearchPage(b, 0)
+	if n := page.EntriesLength(); n > 0 {
+		page.Entries(&SearchEntry{}, n-1)
+	}
+	return page, nil
+}
+
+// recoverMalformed recovers from a panic while reading malformed data and returns it as err instead,
+// it must be deferred directly. Flatbuffers trust the offsets in the data and panic when reading out
+// of bounds. Other functions validate their input with NewSearchPageFromBytesSafe or
+// NewSearchEntryFromBytesSafe instead of recovering themselves.
+func recoverMalformed(err *error, op string) {
+	if r := recover(); r != nil {
+		*err = fmt.Errorf("error %s: malformed data: %v", op, r)
+	}
+}
+
+// Contains is the same as PageContains.
 func (s *SearchPage) Contains(k []byte, v []byte, buffer *KeyValues) bool {
-	return ContainsTag(s, buffer, k, v)
+	return PageContains(s, k, v, buffer)
+}
+
+// Reset rebinds the page to new bytes so the object can be reused, like SearchEntry.Reset.
+func (s *SearchPage) Reset(b []byte) {
+	n := flatbuffers.GetUOffsetT(b)
+	s.Init(b, n)
+}
+
+// HasTags returns false if the page was written without page-level tags, see SkipBatchTags.
+func (s *SearchPage) HasTags() bool {
+	return s._tab.Offset(4) != 0
+}
+
+// PageContains checks the page-level tags, which are the union of all entries in the page, to
+// determine if any entry in the page could contain the key and value. When false the page can be
+// skipped entirely, otherwise the entries must be scanned individually to find matches.
+// Always true for pages without page-level tags.
+func PageContains(page *SearchPage, k []byte, v []byte, buffer *KeyValues) bool {
+	if !page.HasTags() {
+		return true
+	}
+	return ContainsTag(page, buffer, k, v)
+}
+
+// MergePages combines the entries of all pages into a single new page. The page-level tags
+// of the result are the union of the inputs. Returns an error if any page is malformed.
+func MergePages(pages [][]byte) ([]byte, error) {
+	b := NewSearchPageBuilder()
+	for i, p := range pages {
+		page, err := NewSearchPageFromBytesSafe(p)
+		if err != nil {
+			return nil, fmt.Errorf("error merging search pages: page %d: %w", i, err)
+		}
+
+		ForeachEntry(page, func(e *SearchEntry) bool {
+			b.AddData(FromSearchEntry(e))
+			return true
+		})
+	}
+
+	return b.Finish(), nil
+}
+
+// AppendToPage returns a new page with the entries of the existing page followed by the given
+// entries, and the union of their page-level tags. Existing entries are copied without decoding
+// them. If the existing page has no page-level tags, neither does the result. Returns an error if
+// the existing page is malformed.
+func AppendToPage(existing []byte, entries []*SearchEntryMutable) ([]byte, error) {
+	p, err := NewSearchPageFromBytesSafe(existing)
+	if err != nil {
+		return nil, fmt.Errorf("error appending to search page: %w", err)
+	}
+
+	b := NewSearchPageBuilderWithOpts(SearchPageBuilderOpts{SkipBatchTags: !p.HasTags()})
+
+	kv := &KeyValues{}
+	for i, l := 0, p.TagsLength(); i < l; i++ {
+		p.Tags(kv, i)
+		for j, vl := 0, kv.ValueLength(); j < vl; j++ {
+			b.addPageTag(string(kv.Key()), string(kv.Value(j)))
+		}
+	}
+
+	ForeachEntry(p, func(e *SearchEntry) bool {
+		b.addEncodedEntry(e)
+		return true
+	})
+
+	for _, e := range entries {
+		b.AddData(e)
+	}
+
+	return b.Finish(), nil
+}
+
+// CountMatching returns the number of entries in the page for which the predicate returns true.
+// The entry passed to the predicate is a reused buffer.
+func CountMatching(page *SearchPage, pred func(*SearchEntry) bool) int {
+	count := 0
+	ForeachEntry(page, func(e *SearchEntry) bool {
+		if pred(e) {
+			count++
+		}
+		return true
+	})
+	return count
+}
+
+// MatchingTraceIDs returns the trace IDs of up to limit entries for which the predicate returns true.
+// Zero limit is unlimited. IDs are copied and don't reference the page buffer.
+func MatchingTraceIDs(page *SearchPage, pred func(*SearchEntry) bool, limit int) []common.ID {
+	var ids []common.ID
+	ForeachEntry(page, func(e *SearchEntry) bool {
+		if pred(e) {
+			ids = append(ids, append(common.ID(nil), e.Id()...))
+		}
+		return limit <= 0 || len(ids) < limit
+	})
+	return ids
+}
+
+// DistinctMatchingTraceIDs is like MatchingTraceIDs but returns each trace ID once, even when
+// the trace has multiple entries in the page.
+func DistinctMatchingTraceIDs(page *SearchPage, pred func(*SearchEntry) bool, limit int) []common.ID {
+	var ids []common.ID
+	seen := map[string]struct{}{}
+	ForeachEntry(page, func(e *SearchEntry) bool {
+		if !pred(e) {
+			return true
+		}
+
+		// Converting to string copies the id out of the page buffer.
+		id := string(e.Id())
+		if _, ok := seen[id]; ok {
+			return true
+		}
+		seen[id] = struct{}{}
+
+		ids = append(ids, common.ID(id))
+		return limit <= 0 || len(ids) < limit
+	})
+	return ids
+}
+
+// FindFirstEntryAfter returns the index, in ForeachEntry order, of the first entry with a start time at or
+// after startNano, or the number of entries if there is none. The page must have been written with
+// SortEntriesByStartTime.
+func FindFirstEntryAfter(page *SearchPage, startNano uint64) int {
+	e := &SearchEntry{}
+	n := page.EntriesLength()
+	return lowerBound(n, func(i int) int {
+		entryAt(page, e, i)
+		if e.StartTimeUnixNano() < startNano {
+			return -1
+		}
+		return 1
+	})
+}
+
+// PageTimeBounds returns the earliest start time and latest end time of all entries in the page.
+// Zero times follow the same rules as SearchEntry.Overlaps: a zero start is the epoch and a zero
+// end is open-ended, so if any entry has a zero end time then maxEnd is zero too. This way the
+// bounds overlap a time window whenever any entry does. An empty page returns zeros.
+func PageTimeBounds(page *SearchPage) (minStart, maxEnd uint64) {
+	first, openEnded := true, false
+	ForeachEntry(page, func(e *SearchEntry) bool {
+		start, end := e.StartTimeUnixNano(), e.EndTimeUnixNano()
+		if first || start < minStart {
+			minStart = start
+		}
+		if end == 0 {
+			openEnded = true
+		}
+		if end > maxEnd {
+			maxEnd = end
+		}
+		first = false
+		return true
+	})
+
+	if openEnded {
+		maxEnd = 0
+	}
+	return minStart, maxEnd
+}
+
+// TagCardinality returns the number of distinct values of each key. Like DistinctValuesForKey the
+// counts are taken from the page-level tags, only pages without them are scanned entry by entry. That
+// includes the pages which exceeded MaxDistinctTagsPerPage.
+func TagCardinality(page *SearchPage) map[string]int {
+	kv := &KeyValues{}
+	if page.HasTags() {
+		cardinality := make(map[string]int, page.TagsLength())
+		for i, l := 0, page.TagsLength(); i < l; i++ {
+			page.Tags(kv, i)
+			cardinality[string(kv.Key())] = kv.ValueLength()
+		}
+		return cardinality
+	}
+
+	sets := map[string]map[string]struct{}{}
+	ForeachEntry(page, func(e *SearchEntry) bool {
+		ForeachTag(e, kv, func(key, value []byte) bool {
+			set, ok := sets[string(key)]
+			if !ok {
+				set = map[string]struct{}{}
+				sets[string(key)] = set
+			}
+			set[string(value)] = struct{}{}
+			return true
+		})
+		return true
+	})
+
+	cardinality := make(map[string]int, len(sets))
+	for k, set := range sets {
+		cardinality[k] = len(set)
+	}
+	return cardinality
+}
+
+// DistinctValuesForKey returns up to limit distinct values of the key across all entries, in sorted
+// order. Zero limit is unlimited. The values are read from the page-level tags, which are already the
+// deduplicated union of the entries, only pages without them are scanned entry by entry.
+func DistinctValuesForKey(page *SearchPage, key []byte, limit int) []string {
+	kv := &KeyValues{}
+	if page.HasTags() {
+		if FindTag(page, kv, key) == nil {
+			return nil
+		}
+
+		n := kv.ValueLength()
+		if limit > 0 && limit < n {
+			n = limit
+		}
+		values := make([]string, 0, n)
+		rangeValues(kv, func(_ int, v []byte) bool {
+			values = append(values, string(v))
+			return len(values) < n
+		})
+		return values
+	}
+
+	set := map[string]struct{}{}
+	ForeachEntry(page, func(e *SearchEntry) bool {
+		if FindTag(e, kv, key) != nil {
+			for j, l := 0, kv.ValueLength(); j < l; j++ {
+				set[string(kv.Value(j))] = struct{}{}
+			}
+		}
+		return true
+	})
+	return sortedSet(set, limit)
+}
+
+// DistinctKeys returns up to limit distinct keys across all entries, in sorted order. Zero limit is
+// unlimited. Like DistinctValuesForKey the keys are read from the page-level tags, only pages without
+// them are scanned entry by entry.
+func DistinctKeys(page *SearchPage, limit int) []string {
+	kv := &KeyValues{}
+	if page.HasTags() {
+		n := page.TagsLength()
+		if limit > 0 && limit < n {
+			n = limit
+		}
+		keys := make([]string, 0, n)
+		for i := 0; i < n; i++ {
+			tagAt(page, kv, i)
+			keys = append(keys, string(kv.Key()))
+		}
+		return keys
+	}
+
+	set := map[string]struct{}{}
+	ForeachEntry(page, func(e *SearchEntry) bool {
+		for i, l := 0, e.TagsLength(); i < l; i++ {
+			e.Tags(kv, i)
+			set[string(kv.Key())] = struct{}{}
+		}
+		return true
+	})
+	return sortedSet(set, limit)
+}
+
+// DistinctKeysPaged is DistinctKeys with a resumable cursor for pagination. It returns up to limit
+// keys in sorted order, and the cursor to pass to the next call, which is empty when there are no more
+// keys. An empty cursor starts at the first key. Cursors are opaque, so an empty key can be resumed
+// after like any other. Zero limit returns all remaining keys.
+func DistinctKeysPaged(page *SearchPage, cursor string, limit int) (keys []string, next string) {
+	if !page.HasTags() {
+		all := DistinctKeys(page, 0)
+		return pageSorted(len(all), func(p int) []byte { return []byte(all[p]) }, cursor, limit)
+	}
+
+	kv := &KeyValues{}
+	n := page.TagsLength()
+	return pageSorted(n, func(p int) []byte {
+		tagAt(page, kv, p)
+		return kv.Key()
+	}, cursor, limit)
+}
+
+// DistinctValuesForKeyPaged is DistinctValuesForKey with a resumable cursor, like DistinctKeysPaged.
+func DistinctValuesForKeyPaged(page *SearchPage, key []byte, cursor string, limit int) (values []string, next string) {
+	if !page.HasTags() {
+		all := DistinctValuesForKey(page, key, 0)
+		return pageSorted(len(all), func(p int) []byte { return []byte(all[p]) }, cursor, limit)
+	}
+
+	kv := &KeyValues{}
+	if FindTag(page, kv, key) == nil {
+		return nil, ""
+	}
+	return pageSorted(kv.ValueLength(), func(p int) []byte {
+		return valueAt(kv, p)
+	}, cursor, limit)
+}
+
+// pagedCursorPrefix marks a cursor which resumes after the rest of the cursor, so that resuming after
+// the empty string can't be confused with starting over.
+const pagedCursorPrefix = ">"
+
+// pageSorted returns up to limit of the n sorted strings returned by at which are after the cursor, and
+// the cursor of the next page. The start is found with a binary search.
+func pageSorted(n int, at func(p int) []byte, cursor string, limit int) ([]string, string) {
+	start := 0
+	if cursor != "" {
+		after := strings.TrimPrefix(cursor, pagedCursorPrefix)
+		start = sort.Search(n, func(p int) bool {
+			return string(at(p)) > after
+		})
+	}
+
+	end := n
+	if limit > 0 && start+limit < n {
+		end = start + limit
+	}
+
+	page := make([]string, 0, end-start)
+	for p := start; p < end; p++ {
+		page = append(page, string(at(p)))
+	}
+
+	next := ""
+	if end < n && len(page) > 0 {
+		next = pagedCursorPrefix + page[len(page)-1]
+	}
+	return page, next
+}
+
+// sortedSet returns up to limit members of the set in sorted order. Zero limit is unlimited.
+func sortedSet(set map[string]struct{}, limit int) []string {
+	values := make([]string, 0, len(set))
+	for v := range set {
+		values = append(values, v)
+	}
+	sort.Strings(values)
+	if limit > 0 && limit < len(values) {
+		values = values[:limit]
+	}
+	return values
+}
+
+// BuildInvertedIndex maps every key and value in the page to the trace IDs of the entries that
+// have it, in the order the entries were added. Trace IDs are copied once per entry and shared
+// between the lists the entry appears in, so they must not be modified.
+func BuildInvertedIndex(page *SearchPage) map[string]map[string][]common.ID {
+	index := make(map[string]map[string][]common.ID, page.TagsLength())
+
+	kv := &KeyValues{}
+	ForeachEntry(page, func(e *SearchEntry) bool {
+		id := append(common.ID(nil), e.Id()...)
+		ForeachTag(e, kv, func(key, value []byte) bool {
+			values, ok := index[string(key)]
+			if !ok {
+				values = map[string][]common.ID{}
+				index[string(key)] = values
+			}
+			values[string(value)] = append(values[string(value)], id)
+			return true
+		})
+		return true
+	})
+	return index
+}
+
+// PageInfo is the metadata of a search page, see SearchPageInfo.
+type PageInfo struct {
+	Entries  int
+	MinStart uint64 // See PageTimeBounds for the handling of zero times.
+	MaxEnd   uint64
+	Size     int // Bytes
+}
+
+// SearchPageInfo returns the metadata of the page. Only the time fields of the entries are read,
+// not their tags. Returns an error if the page or the time fields are malformed.
+func SearchPageInfo(b []byte) (PageInfo, error) {
+	info, err := searchPageInfo(b)
+	if err != nil {
+		return PageInfo{}, fmt.Errorf("error reading search page info: %w", err)
+	}
+	return info, nil
+}
+
+func searchPageInfo(b []byte) (_ PageInfo, err error) {
+	page, err := newSearchPageRootSafe(b)
+	if err != nil {
+		return PageInfo{}, err
+	}
+
+	defer recoverMalformed(&err, "decoding search page")
+
+	info := PageInfo{Entries: page.EntriesLength(), Size: len(b)}
+	info.MinStart, info.MaxEnd = PageTimeBounds(page)
+	return info, nil
 }
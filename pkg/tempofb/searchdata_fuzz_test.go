@@ -0,0 +1,42 @@
+//go:build go1.18
+// +build go1.18
+
+package tempofb
+
+import "testing"
+
+// Fuzz targets need testing.F from Go 1.18, the rest of the package builds with 1.17.
+
+func FuzzNewSearchEntryFromBytesSafe(f *testing.F) {
+	buf := testEntryBytes()
+	f.Add(buf)
+	f.Add(buf[:len(buf)/2])
+	f.Add([]byte{0xFF, 0xFF, 0xFF, 0xFF})
+
+	f.Fuzz(func(t *testing.T, b []byte) {
+		// Must not panic
+		_, _ = NewSearchEntryFromBytesSafe(b)
+	})
+}
+
+func FuzzNewSearchPageFromBytesSafe(f *testing.F) {
+	buf := testPageBytes()
+	f.Add(buf)
+	f.Add(buf[:len(buf)/2])
+	f.Add([]byte{0xFF, 0xFF, 0xFF, 0xFF})
+
+	f.Fuzz(func(t *testing.T, b []byte) {
+		// Must not panic
+		_, _ = NewSearchPageFromBytesSafe(b)
+	})
+}
+
+func FuzzSearchEntryRoundTrip(f *testing.F) {
+	for _, in := range searchEntryRoundTripSeeds {
+		f.Add(in.traceID, in.start, in.end, in.k1, in.v1, in.k2, in.v2)
+	}
+
+	f.Fuzz(func(t *testing.T, traceID []byte, start, end uint64, k1, v1, k2, v2 string) {
+		testSearchEntryRoundTrip(t, searchEntryRoundTripInput{traceID, start, end, k1, v1, k2, v2})
+	})
+}
@@ -0,0 +1,32 @@
+package tempofb
+
+import (
+	"encoding/hex"
+	"encoding/json"
+)
+
+type searchEntryJSON struct {
+	TraceID string              `json:"traceID"`
+	Start   uint64              `json:"start"`
+	End     uint64              `json:"end"`
+	Tags    map[string][]string `json:"tags"`
+}
+
+// MarshalJSON encodes the entry for debugging purposes. The trace ID is lowercase hex and
+// tag values are sorted.
+func (s *SearchEntry) MarshalJSON() ([]byte, error) {
+	e := searchEntryJSON{
+		TraceID: hex.EncodeToString(s.Id()),
+		Start:   s.StartTimeUnixNano(),
+		End:     s.EndTimeUnixNano(),
+		Tags:    make(map[string][]string, s.TagsLength()),
+	}
+
+	ForeachTag(s, &KeyValues{}, func(key, value []byte) bool {
+		k := string(key)
+		e.Tags[k] = append(e.Tags[k], string(value))
+		return true
+	})
+
+	return json.Marshal(e)
+}
@@ -0,0 +1,23 @@
+package tempofb
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSearchEntryMarshalJSON(t *testing.T) {
+	m := &SearchEntryMutable{
+		TraceID:           []byte{0x0A, 0xBC},
+		StartTimeUnixNano: 1,
+		EndTimeUnixNano:   2,
+	}
+	m.AddTag("key2", "value2")
+	m.AddTag("key1", "value1b")
+	m.AddTag("key1", "value1a")
+
+	b, err := json.Marshal(NewSearchEntryFromBytes(m.ToBytes()))
+	require.NoError(t, err)
+	require.JSONEq(t, `{"traceID":"0abc","start":1,"end":2,"tags":{"key1":["value1a","value1b"],"key2":["value2"]}}`, string(b))
+}
@@ -0,0 +1,147 @@
+package tempofb
+
+import (
+	"encoding/binary"
+	"math"
+	"strings"
+	"testing"
+
+	flatbuffers "github.com/google/flatbuffers/go"
+	"github.com/stretchr/testify/require"
+)
+
+func testPageBytes() []byte {
+	b := NewSearchPageBuilder()
+	for i := 0; i < 3; i++ {
+		e := &SearchEntryMutable{TraceID: []byte{byte(i)}, StartTimeUnixNano: 1, EndTimeUnixNano: 2}
+		e.AddTag("service.name", "svc")
+		e.AddTag("http.status_code", "200")
+		b.AddData(e)
+	}
+	return b.Finish()
+}
+
+// corruptVectorLength sets the length of the vector in the vtable slot of the table to the maximum.
+func corruptVectorLength(t flatbuffers.Table, slot flatbuffers.VOffsetT) {
+	binary.LittleEndian.PutUint32(t.Bytes[t.Vector(flatbuffers.UOffsetT(t.Offset(slot)))-4:], math.MaxUint32)
+}
+
+func testEntryBytes() []byte {
+	e := &SearchEntryMutable{TraceID: []byte{1, 2, 3}, StartTimeUnixNano: 1, EndTimeUnixNano: 2}
+	e.AddTag("service.name", "svc")
+	e.AddTag("HTTP.Method", "GET") // written with original tags
+	return e.ToBytes()
+}
+
+func TestNewSearchEntryFromBytesSafe(t *testing.T) {
+	buf := testEntryBytes()
+
+	e, err := NewSearchEntryFromBytesSafe(buf)
+	require.NoError(t, err)
+	require.Equal(t, "svc", e.Get("service.name"))
+
+	for _, b := range [][]byte{nil, {0x01}, buf[:len(buf)/2], {0xFF, 0xFF, 0xFF, 0xFF, 0x00}} {
+		_, err = NewSearchEntryFromBytesSafe(b)
+		require.Error(t, err)
+	}
+}
+
+func TestNewSearchEntryFromBytesSafeValueTypes(t *testing.T) {
+	m := &SearchEntryMutable{TraceID: []byte{1}}
+	m.AddTagTyped("status", "200", ValueTypeInt)
+	buf := m.ToBytes()
+
+	e, err := NewSearchEntryFromBytesSafe(buf)
+	require.NoError(t, err)
+	require.Equal(t, ValueTypeInt, e.ValueType([]byte("status"), 0))
+
+	// Corrupt the length of the value type vector
+	corruptVectorLength(FindTag(e, &KeyValues{}, []byte("status"))._tab, 8)
+	_, err = NewSearchEntryFromBytesSafe(buf)
+	require.Error(t, err)
+}
+
+func TestNewSearchPageFromBytesSafe(t *testing.T) {
+	buf := testPageBytes()
+
+	page, err := NewSearchPageFromBytesSafe(buf)
+	require.NoError(t, err)
+	require.Equal(t, 3, page.EntriesLength())
+
+	for _, b := range [][]byte{nil, {0x01}, buf[:len(buf)/2], {0xFF, 0xFF, 0xFF, 0xFF, 0x00}} {
+		_, err = NewSearchPageFromBytesSafe(b)
+		require.Error(t, err)
+	}
+}
+
+type searchEntryRoundTripInput struct {
+	traceID    []byte
+	start, end uint64
+	k1, v1     string
+	k2, v2     string
+}
+
+// searchEntryRoundTripSeeds are the seeds of FuzzSearchEntryRoundTrip, which also run as a
+// regular test with Go 1.17.
+var searchEntryRoundTripSeeds = []searchEntryRoundTripInput{
+	{[]byte("0123456789abcdef"), 1, 2, "service.name", "svc", "http.method", "GET"},
+	{[]byte{}, 0, 0, "", "", "", ""},
+	{[]byte{1}, 2, 1, "key", "a", "key", "b"},
+	{[]byte{1}, 0, 0, "ключ", "значение", "键", "值"},
+	{[]byte{1}, 0, 0, "key", string(make([]byte, 1<<16)), "other", ""},
+}
+
+func testSearchEntryRoundTrip(t *testing.T, in searchEntryRoundTripInput) {
+	m := &SearchEntryMutable{TraceID: in.traceID, StartTimeUnixNano: in.start, EndTimeUnixNano: in.end}
+	m.AddTag(in.k1, in.v1)
+	m.AddTag(in.k2, in.v2)
+
+	// Keys and values are lowercased when written
+	expected := map[string]map[string]struct{}{}
+	m.Tags.Range(func(k, v string) {
+		k, v = strings.ToLower(k), strings.ToLower(v)
+		if expected[k] == nil {
+			expected[k] = map[string]struct{}{}
+		}
+		expected[k][v] = struct{}{}
+	})
+
+	e, err := NewSearchEntryFromBytesSafe(m.ToBytes())
+	require.NoError(t, err)
+	require.Equal(t, in.traceID, append([]byte{}, e.Id()...))
+	require.Equal(t, in.start, e.StartTimeUnixNano())
+	require.Equal(t, in.end, e.EndTimeUnixNano())
+
+	actual := map[string]map[string]struct{}{}
+	kv := &KeyValues{}
+	for i := 0; i < e.TagsLength(); i++ {
+		e.Tags(kv, i)
+		k := string(kv.Key())
+		require.NotContains(t, actual, k, "duplicate key")
+		actual[k] = map[string]struct{}{}
+		for j := 0; j < kv.ValueLength(); j++ {
+			actual[k][string(kv.Value(j))] = struct{}{}
+		}
+	}
+	require.Equal(t, expected, actual)
+
+	// Every key and value can be found
+	for k, values := range expected {
+		for v := range values {
+			require.True(t, e.ContainsExact([]byte(k), []byte(v), kv), "key %q value %q", k, v)
+		}
+	}
+}
+
+func TestSearchEntryRoundTrip(t *testing.T) {
+	inputs := append([]searchEntryRoundTripInput{}, searchEntryRoundTripSeeds...)
+	inputs = append(inputs,
+		// testdata/fuzz/FuzzSearchEntryRoundTrip/f377179b85defefb, invalid UTF-8 which lowercases
+		// to the same key
+		searchEntryRoundTripInput{[]byte("0"), 2, 1, "\xb2", "0", "\xce", "0"},
+	)
+
+	for _, in := range inputs {
+		testSearchEntryRoundTrip(t, in)
+	}
+}
@@ -2,8 +2,10 @@ package tempofb
 
 import (
 	"fmt"
+	"regexp"
 	"testing"
 
+	"github.com/grafana/tempo/tempodb/encoding/common"
 	"github.com/stretchr/testify/require"
 )
 
@@ -72,6 +74,164 @@ func TestSearchEntryMutableSetEndTimeUnixNano(t *testing.T) {
 	}
 }
 
+func TestSearchEntryMutableRemoveTag(t *testing.T) {
+	e := &SearchEntryMutable{}
+	e.RemoveTag("key1")
+	e.RemoveTagValue("key1", "value1")
+
+	e.AddTag("key1", "value1")
+	e.AddTag("key2", "value1")
+	e.AddTag("key2", "value2")
+	e.AddTag("key3", "value1")
+
+	e.RemoveTag("key1")
+	e.RemoveTagValue("key2", "value1")
+	e.RemoveTagValue("key3", "value1")
+	e.RemoveTagValue("key3", "does-not-exist")
+
+	sd := NewSearchEntryFromBytes(e.ToBytes())
+	require.Nil(t, sd.GetAll("key1"))
+	require.Equal(t, []string{"value2"}, sd.GetAll("key2"))
+	require.Nil(t, sd.GetAll("key3"))
+}
+
+func TestSearchEntryMutableClone(t *testing.T) {
+	e := &SearchEntryMutable{
+		TraceID:           []byte{0x01, 0x02},
+		StartTimeUnixNano: 1,
+		EndTimeUnixNano:   2,
+	}
+	e.AddTag("key1", "value1")
+
+	c := e.Clone()
+	require.Equal(t, e.ToBytes(), c.ToBytes())
+
+	c.TraceID[0] = 0xFF
+	c.AddTag("key2", "value2")
+	c.RemoveTag("key1")
+
+	require.Equal(t, []byte{0x01, 0x02}, []byte(e.TraceID))
+	require.True(t, e.Tags.Contains("key1", "value1"))
+	require.False(t, e.Tags.Contains("key2", "value2"))
+}
+
+func TestSearchEntryMutableReset(t *testing.T) {
+	fill := func(e *SearchEntryMutable) {
+		e.TraceID = append(e.TraceID, 0x01, 0x02)
+		e.SetStartTimeUnixNano(1)
+		e.SetEndTimeUnixNano(2)
+		e.AddTag("key1", "value1")
+	}
+
+	e := NewSearchEntryMutable()
+	e.TraceID = []byte{0x03, 0x04, 0x05}
+	e.SetStartTimeUnixNano(10)
+	e.SetEndTimeUnixNano(20)
+	e.AddTag("key2", "value2")
+	e.Reset()
+	fill(e)
+
+	fresh := NewSearchEntryMutable()
+	fill(fresh)
+
+	require.Equal(t, fresh.ToBytes(), e.ToBytes())
+}
+
+func TestSearchEntryMutableMerge(t *testing.T) {
+	a := &SearchEntryMutable{TraceID: []byte{0x01}, StartTimeUnixNano: 2, EndTimeUnixNano: 3}
+	a.AddTag("key1", "value1")
+
+	b := &SearchEntryMutable{TraceID: []byte{0x01}, StartTimeUnixNano: 1, EndTimeUnixNano: 4}
+	b.AddTag("key1", "value1")
+	b.AddTag("key1", "value2")
+	b.AddTag("key2", "value1")
+
+	require.NoError(t, a.Merge(b))
+	require.Equal(t, uint64(1), a.StartTimeUnixNano)
+	require.Equal(t, uint64(4), a.EndTimeUnixNano)
+
+	sd := NewSearchEntryFromBytes(a.ToBytes())
+	require.Equal(t, []string{"value1", "value2"}, sd.GetAll("key1"))
+	require.Equal(t, []string{"value1"}, sd.GetAll("key2"))
+
+	require.Equal(t, ErrMergeTraceIDMismatch, a.Merge(&SearchEntryMutable{TraceID: []byte{0x02}}))
+}
+
+func TestSearchEntryMutableToBytesPooled(t *testing.T) {
+	e := &SearchEntryMutable{TraceID: []byte{0x01}}
+	e.AddTag("key1", "value1")
+
+	b1 := e.ToBytesPooled()
+	b2 := e.ToBytesPooled()
+	require.Equal(t, e.ToBytes(), b1)
+	require.Equal(t, b1, b2)
+}
+
+func TestFromSearchEntry(t *testing.T) {
+	m := &SearchEntryMutable{
+		TraceID:           []byte{0x01, 0x02},
+		StartTimeUnixNano: 1,
+		EndTimeUnixNano:   2,
+	}
+	m.AddTag("key1", "value1")
+	m.AddTag("key1", "value2")
+	m.AddTag("key2", "value3")
+
+	buf := m.ToBytes()
+	e := FromSearchEntry(NewSearchEntryFromBytes(buf))
+
+	// Clobber the source buffer to ensure nothing references it.
+	for i := range buf {
+		buf[i] = 0
+	}
+
+	require.Equal(t, m.TraceID, e.TraceID)
+	require.Equal(t, m.StartTimeUnixNano, e.StartTimeUnixNano)
+	require.Equal(t, m.EndTimeUnixNano, e.EndTimeUnixNano)
+	require.Equal(t, m.ToBytes(), e.ToBytes())
+}
+
+func TestSearchEntryMutableValidate(t *testing.T) {
+	id := make([]byte, 16)
+
+	testCases := []struct {
+		name    string
+		entry   *SearchEntryMutable
+		isValid bool
+	}{
+		{"valid", &SearchEntryMutable{TraceID: id, StartTimeUnixNano: 1, EndTimeUnixNano: 2}, true},
+		{"no times", &SearchEntryMutable{TraceID: id}, true},
+		{"only start", &SearchEntryMutable{TraceID: id, StartTimeUnixNano: 2}, true},
+		{"short id", &SearchEntryMutable{TraceID: id[:8]}, false},
+		{"no id", &SearchEntryMutable{}, false},
+		{"start after end", &SearchEntryMutable{TraceID: id, StartTimeUnixNano: 2, EndTimeUnixNano: 1}, false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.entry.Validate()
+			if tc.isValid {
+				require.NoError(t, err)
+			} else {
+				require.Error(t, err)
+			}
+		})
+	}
+}
+
+func TestSearchEntryMutableToBytesLimited(t *testing.T) {
+	e := &SearchEntryMutable{}
+	e.AddTag("key", "value")
+	size := len(e.ToBytes())
+
+	b, err := e.ToBytesLimited(size)
+	require.NoError(t, err)
+	require.Equal(t, e.ToBytes(), b)
+
+	_, err = e.ToBytesLimited(size - 1)
+	require.EqualError(t, err, fmt.Sprintf("search entry too large: %d bytes exceeds limit of %d bytes", size, size-1))
+}
+
 func TestEncodingSize(t *testing.T) {
 	delta := 1000
 
@@ -92,6 +252,127 @@ func TestEncodingSize(t *testing.T) {
 	fmt.Printf("  - Value:    %.1f bytes after\n", float32(tagValueLongTermValues-tagValueBaseLine)/float32(delta))
 }
 
+func TestSearchPageBuilderLimits(t *testing.T) {
+	b := NewSearchPageBuilderWithOpts(SearchPageBuilderOpts{
+		MaxTagsPerEntry:        2,
+		MaxDistinctTagsPerPage: 3,
+	})
+
+	e1 := &SearchEntryMutable{}
+	e1.AddTag("key1", "value1")
+	e1.AddTag("key2", "value1")
+	e1.AddTag("key2", "value2")
+	b.AddData(e1)
+
+	e2 := &SearchEntryMutable{}
+	e2.AddTag("key3", "value1")
+	e2.AddTag("key4", "value1")
+	b.AddData(e2)
+
+	require.Equal(t, 1, b.DroppedTags())
+	require.Equal(t, 3, e1.Tags.ValueCount(), "input must not be modified")
+
+	page := GetRootAsSearchPage(b.Finish(), 0)
+	entry := &SearchEntry{}
+
+	// Entries are written in reverse order
+	page.Entries(entry, 1)
+	require.Equal(t, []string{"key1", "key2"}, entry.Keys())
+	require.Equal(t, []string{"value1"}, entry.GetAll("key2"))
+
+	page.Entries(entry, 0)
+	require.Equal(t, []string{"key3", "key4"}, entry.Keys())
+
+	// The page-level tags are incomplete and not written, so the page can't exclude the dropped pair
+	kv := &KeyValues{}
+	require.False(t, page.HasTags())
+	require.True(t, PageContains(page, []byte("key4"), []byte("value1"), kv))
+	require.Equal(t, []string{"key1", "key2", "key3", "key4"}, DistinctKeys(page, 0))
+}
+
+func TestSearchPageBuilderMaxDistinctTagsPerPage(t *testing.T) {
+	b := NewSearchPageBuilderWithOpts(SearchPageBuilderOpts{MaxDistinctTagsPerPage: 1})
+
+	e1 := &SearchEntryMutable{TraceID: []byte{1}}
+	e1.AddTag("a", "1")
+	b.AddData(e1)
+	e2 := &SearchEntryMutable{TraceID: []byte{2}}
+	e2.AddTag("b", "2")
+	b.AddData(e2)
+
+	page := GetRootAsSearchPage(b.Finish(), 0)
+	kv := &KeyValues{}
+	require.False(t, page.HasTags())
+	require.True(t, PageContains(page, []byte("b"), []byte("2"), kv))
+	require.True(t, NewCompiledQuery(map[string][]string{"b": {"2"}}, 0, 0).MatchesPage(page, kv))
+	require.Equal(t, []string{"a", "b"}, DistinctKeys(page, 0))
+	require.Equal(t, []string{"2"}, DistinctValuesForKey(page, []byte("b"), 0))
+
+	// Within the limit after Reset
+	b.Reset()
+	b.AddData(e1)
+	page = GetRootAsSearchPage(b.Finish(), 0)
+	require.True(t, page.HasTags())
+	require.False(t, PageContains(page, []byte("b"), []byte("2"), kv))
+}
+
+func TestSearchPageBuilderEntryCountAndLen(t *testing.T) {
+	b := NewSearchPageBuilder()
+	for i := 0; i < 3; i++ {
+		e := &SearchEntryMutable{}
+		e.AddTag("key", fmt.Sprintf("value%d", i))
+		b.AddData(e)
+	}
+
+	require.Equal(t, 3, b.EntryCount())
+	require.Equal(t, 0, b.Len())
+
+	buf := b.Finish()
+	require.Equal(t, 3, b.EntryCount())
+	require.Equal(t, len(buf), b.Len())
+
+	b.Reset()
+	require.Equal(t, 0, b.EntryCount())
+	require.Equal(t, 0, b.Len())
+}
+
+func TestSearchPageBuilderFull(t *testing.T) {
+	maxBytes := 10 * 1024
+	b := NewSearchPageBuilderWithLimit(maxBytes)
+
+	for i := 0; !b.Full(); i++ {
+		e := &SearchEntryMutable{TraceID: []byte(fmt.Sprintf("trace%d", i))}
+		e.AddTag("key", "value")
+		e.AddTag(fmt.Sprintf("key%d", i%5), fmt.Sprintf("value%d", i))
+		b.AddData(e)
+	}
+	require.Greater(t, b.CurrentSize(), 0)
+
+	require.False(t, NewSearchPageBuilder().Full())
+}
+
+func TestSearchPageBuilderFullIsUpperBound(t *testing.T) {
+	build := func(b *SearchPageBuilder, n int) {
+		for i := 0; i < n; i++ {
+			e := &SearchEntryMutable{TraceID: []byte(fmt.Sprintf("trace%d", i))}
+			e.AddTag(fmt.Sprintf("key%d", i%7), fmt.Sprintf("value%d", i))
+			b.AddData(e)
+		}
+	}
+
+	for _, n := range []int{0, 1, 10, 100, 1000} {
+		finished := NewSearchPageBuilder()
+		build(finished, n)
+		size := len(finished.Finish())
+
+		// The estimate must never be smaller than the real size.
+		b := NewSearchPageBuilderWithLimit(size - 1)
+		build(b, n)
+		require.True(t, b.Full(), "entries=%d size=%d", n, size)
+		require.GreaterOrEqual(t, b.EstimatedTotalSize(), size)
+	}
+}
+
 func TestContainsTag(t *testing.T) {
 	m := &SearchEntryMutable{}
 	m.AddTag("key1", "value")
@@ -122,3 +403,1749 @@ func TestContainsTag(t *testing.T) {
 		})
 	}
 }
+
+func TestSearchEntryGet(t *testing.T) {
+	m := &SearchEntryMutable{}
+	m.AddTag("key1", "value1")
+	m.AddTag("key2", "value2")
+	m.AddTag("key3", "value3")
+
+	e := NewSearchEntryFromBytes(m.ToBytes())
+
+	require.Equal(t, "value1", e.Get("key1"))
+	require.Equal(t, "value2", e.Get("KEY2"))
+	require.Equal(t, "value3", e.Get("key3"))
+	require.Equal(t, "", e.Get("key4"))
+}
+
+func TestSearchEntryGetAll(t *testing.T) {
+	m := &SearchEntryMutable{}
+	m.AddTag("key1", "value2")
+	m.AddTag("key1", "value1")
+	m.AddTag("key1", "value3")
+	m.AddTag("key2", "value3")
+
+	e := NewSearchEntryFromBytes(m.ToBytes())
+
+	// Sorted regardless of insertion order
+	require.Equal(t, []string{"value1", "value2", "value3"}, e.GetAll("key1"))
+	require.Equal(t, []string{"value3"}, e.GetAll("KEY2"))
+	require.Nil(t, e.GetAll("key3"))
+}
+
+func TestSearchEntryHasTag(t *testing.T) {
+	m := &SearchEntryMutable{}
+	m.AddTag("key1", "value1")
+
+	e := NewSearchEntryFromBytes(m.ToBytes())
+
+	require.True(t, e.HasTag("key1"))
+	require.True(t, e.HasTagBuffer("KEY1", &KeyValues{}))
+	require.False(t, e.HasTag("key2"))
+}
+
+func TestSearchEntryKeys(t *testing.T) {
+	m := &SearchEntryMutable{}
+	m.AddTag("key2", "value1")
+	m.AddTag("key1", "value1")
+	m.AddTag("key3", "value1")
+	m.AddTag("key3", "value2")
+
+	e := NewSearchEntryFromBytes(m.ToBytes())
+	require.Equal(t, []string{"key1", "key2", "key3"}, e.Keys())
+
+	empty := NewSearchEntryFromBytes((&SearchEntryMutable{}).ToBytes())
+	require.Empty(t, empty.Keys())
+}
+
+func TestSearchEntryForeachTagValue(t *testing.T) {
+	m := &SearchEntryMutable{}
+	m.AddTag("key1", "value2")
+	m.AddTag("key1", "value1")
+	m.AddTag("key2", "value3")
+
+	e := NewSearchEntryFromBytes(m.ToBytes())
+
+	var values []string
+	e.ForeachTagValue("key1", func(v []byte) {
+		values = append(values, string(v))
+	})
+	require.Equal(t, []string{"value1", "value2"}, values)
+	require.Equal(t, e.GetAll("key1"), values)
+
+	called := false
+	e.ForeachTagValue("key3", func(v []byte) {
+		called = true
+	})
+	require.False(t, called)
+}
+
+func TestSearchEntryCaseSensitive(t *testing.T) {
+	m := &SearchEntryMutable{TraceID: []byte{1}}
+	m.AddTag("K8s.App", "Checkout")
+	m.AddTag("k8s.app", "cart")
+	m.AddTagTyped("Status", "200", ValueTypeInt)
+
+	e := NewSearchEntryFromBytes(m.ToBytes())
+	kv := &KeyValues{}
+
+	require.Equal(t, "Checkout", e.GetCaseSensitive("K8s.App"))
+	require.Equal(t, "cart", e.GetCaseSensitive("k8s.app"))
+	require.Equal(t, "", e.GetCaseSensitive("K8S.APP"))
+	require.True(t, e.ContainsCaseSensitive([]byte("K8s.App"), []byte("Check"), kv))
+	require.False(t, e.ContainsCaseSensitive([]byte("K8s.App"), []byte("check"), kv))
+	require.False(t, e.ContainsCaseSensitive([]byte("k8s.app"), []byte("Checkout"), kv))
+
+	// Normalized lookups are unaffected
+	require.Equal(t, []string{"cart", "checkout"}, e.GetAll("K8s.App"))
+	require.True(t, e.Contains([]byte("k8s.app"), []byte("checkout"), kv))
+
+	// Kept by the mutable form and by copying into pages
+	require.Equal(t, m.ToBytes(), FromSearchEntry(e).ToBytes())
+	require.Equal(t, ValueTypeInt, NewSearchEntryFromBytes(FromSearchEntry(e).ToBytes()).ValueType([]byte("status"), 0))
+
+	page, err := AppendToPage(NewSearchPageBuilder().Finish(), []*SearchEntryMutable{m})
+	require.NoError(t, err)
+	page, err = AppendToPage(page, nil)
+	require.NoError(t, err)
+	merged, err := MergePages([][]byte{page})
+	require.NoError(t, err)
+	for _, p := range [][]byte{page, merged} {
+		ForeachEntry(GetRootAsSearchPage(p, 0), func(e *SearchEntry) bool {
+			require.Equal(t, "Checkout", e.GetCaseSensitive("K8s.App"))
+			return true
+		})
+	}
+
+	// Lowercase entries don't write original tags, their tags are the same
+	lower := &SearchEntryMutable{}
+	lower.AddTag("key1", "value1")
+	e = NewSearchEntryFromBytes(lower.ToBytes())
+	require.Equal(t, 0, e.OriginalTagsLength())
+	require.Equal(t, "value1", e.GetCaseSensitive("key1"))
+	require.True(t, e.ContainsCaseSensitive([]byte("key1"), []byte("value1"), kv))
+	require.False(t, e.ContainsCaseSensitive([]byte("KEY1"), []byte("value1"), kv))
+}
+
+func BenchmarkSearchEntryGet(b *testing.B) {
+	m := &SearchEntryMutable{}
+	for i := 0; i < 300; i++ {
+		m.AddTag(fmt.Sprintf("key%d", i), fmt.Sprintf("value%d", i))
+	}
+	e := NewSearchEntryFromBytes(m.ToBytes())
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		e.Get("key150")
+	}
+}
+
+func BenchmarkSearchEntryMutableToBytes(b *testing.B) {
+	e := &SearchEntryMutable{TraceID: []byte("0123456789abcdef")}
+	for i := 0; i < 20; i++ {
+		e.AddTag(fmt.Sprintf("key%d", i), fmt.Sprintf("value%d", i))
+	}
+
+	b.Run("ToBytes", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			e.ToBytes()
+		}
+	})
+
+	b.Run("ToBytesPooled", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			e.ToBytesPooled()
+		}
+	})
+}
+
+func TestSearchEntryContainsAllAny(t *testing.T) {
+	m := &SearchEntryMutable{}
+	m.AddTag("key1", "value1")
+	m.AddTag("key2", "value2")
+
+	e := NewSearchEntryFromBytes(m.ToBytes())
+	kv := &KeyValues{}
+
+	pair := func(k, v string) TagPair {
+		return TagPair{[]byte(k), []byte(v)}
+	}
+
+	testCases := []struct {
+		name     string
+		pairs    []TagPair
+		all, any bool
+	}{
+		{"none", nil, true, false},
+		{"both match", []TagPair{pair("key1", "value1"), pair("key2", "value2")}, true, true},
+		{"one match", []TagPair{pair("key1", "value1"), pair("key2", "value3")}, false, true},
+		{"no match", []TagPair{pair("key1", "value2"), pair("key3", "value3")}, false, false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			require.Equal(t, tc.all, e.ContainsAll(tc.pairs, kv))
+			require.Equal(t, tc.any, e.ContainsAny(tc.pairs, kv))
+		})
+	}
+}
+
+func TestContainsTagPrefix(t *testing.T) {
+	m := &SearchEntryMutable{}
+	m.AddTag("http.url", "/api/v1/traces")
+
+	e := NewSearchEntryFromBytes(m.ToBytes())
+	kv := &KeyValues{}
+
+	testCases := []struct {
+		key, value        string
+		prefix, substring bool
+	}{
+		{"http.url", "/api/", true, true},
+		{"http.url", "/v1/", false, true},
+		{"http.url", "/api/v1/traces/x", false, false},
+		{"missing", "/api/", false, false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(fmt.Sprint(tc.key, "=", tc.value), func(t *testing.T) {
+			require.Equal(t, tc.prefix, e.ContainsPrefix([]byte(tc.key), []byte(tc.value), kv))
+			require.Equal(t, tc.substring, e.Contains([]byte(tc.key), []byte(tc.value), kv))
+		})
+	}
+}
+
+func TestContainsTagRegex(t *testing.T) {
+	m := &SearchEntryMutable{}
+	m.AddTag("status", "503")
+	m.AddTag("status", "200")
+
+	e := NewSearchEntryFromBytes(m.ToBytes())
+	kv := &KeyValues{}
+
+	require.True(t, e.ContainsRegex([]byte("status"), regexp.MustCompile("^5..$"), kv))
+	require.False(t, e.ContainsRegex([]byte("status"), regexp.MustCompile("^4..$"), kv))
+	require.False(t, e.ContainsRegex([]byte("missing"), regexp.MustCompile(".*"), kv))
+}
+
+func TestForeachEntry(t *testing.T) {
+	b := NewSearchPageBuilder()
+	for i := 0; i < 5; i++ {
+		b.AddData(&SearchEntryMutable{TraceID: []byte{byte(i)}})
+	}
+	page := GetRootAsSearchPage(b.Finish(), 0)
+
+	var ids []byte
+	ForeachEntry(page, func(e *SearchEntry) bool {
+		ids = append(ids, e.Id()...)
+		return true
+	})
+	require.Equal(t, []byte{0, 1, 2, 3, 4}, ids)
+
+	ids = nil
+	ForeachEntry(page, func(e *SearchEntry) bool {
+		ids = append(ids, e.Id()...)
+		return len(ids) < 2
+	})
+	require.Equal(t, []byte{0, 1}, ids)
+}
+
+func TestPageContains(t *testing.T) {
+	b := NewSearchPageBuilder()
+	e1 := &SearchEntryMutable{}
+	e1.AddTag("key1", "value1")
+	b.AddData(e1)
+	e2 := &SearchEntryMutable{}
+	e2.AddTag("key2", "value2")
+	b.AddData(e2)
+
+	page := GetRootAsSearchPage(b.Finish(), 0)
+	kv := &KeyValues{}
+
+	require.True(t, PageContains(page, []byte("key1"), []byte("value1"), kv))
+	require.True(t, PageContains(page, []byte("key2"), []byte("value2"), kv))
+	require.False(t, PageContains(page, []byte("key1"), []byte("value2"), kv))
+	require.False(t, PageContains(page, []byte("key3"), []byte("value1"), kv))
+}
+
+func TestForeachEntryInTimeRange(t *testing.T) {
+	b := NewSearchPageBuilder()
+	b.AddData(&SearchEntryMutable{TraceID: []byte{1}, StartTimeUnixNano: 10, EndTimeUnixNano: 20})
+	b.AddData(&SearchEntryMutable{TraceID: []byte{2}, StartTimeUnixNano: 30, EndTimeUnixNano: 40})
+	b.AddData(&SearchEntryMutable{TraceID: []byte{3}, StartTimeUnixNano: 50})
+	page := GetRootAsSearchPage(b.Finish(), 0)
+
+	testCases := []struct {
+		start, end uint64
+		expected   []byte
+	}{
+		{0, 0, []byte{1, 2, 3}},
+		{15, 35, []byte{1, 2}},
+		{21, 29, nil},
+		{40, 0, []byte{2, 3}},
+		{100, 200, []byte{3}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(fmt.Sprint(tc.start, "-", tc.end), func(t *testing.T) {
+			var ids []byte
+			ForeachEntryInTimeRange(page, tc.start, tc.end, func(e *SearchEntry) bool {
+				ids = append(ids, e.Id()...)
+				return true
+			})
+			require.Equal(t, tc.expected, ids)
+		})
+	}
+}
+
+func TestSearchEntryOverlaps(t *testing.T) {
+	testCases := []struct {
+		name                   string
+		entryStart, entryEnd   uint64
+		windowStart, windowEnd uint64
+		expected               bool
+	}{
+		{"inside", 10, 20, 0, 100, true},
+		{"contains window", 10, 20, 12, 18, true},
+		{"partial start", 10, 20, 5, 15, true},
+		{"partial end", 10, 20, 15, 25, true},
+		{"touches start", 10, 20, 0, 10, true},
+		{"touches end", 10, 20, 20, 30, true},
+		{"before", 10, 20, 0, 9, false},
+		{"after", 10, 20, 21, 30, false},
+		{"open-ended entry", 10, 0, 100, 200, true},
+		{"open-ended entry after window", 10, 0, 0, 9, false},
+		{"open-ended window", 10, 20, 15, 0, true},
+		{"open-ended window after entry", 10, 20, 21, 0, false},
+		{"zero entry", 0, 0, 100, 200, true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			m := &SearchEntryMutable{StartTimeUnixNano: tc.entryStart, EndTimeUnixNano: tc.entryEnd}
+			e := NewSearchEntryFromBytes(m.ToBytes())
+			require.Equal(t, tc.expected, e.Overlaps(tc.windowStart, tc.windowEnd))
+		})
+	}
+}
+
+func TestMergePages(t *testing.T) {
+	page := func(ids ...byte) []byte {
+		b := NewSearchPageBuilder()
+		for _, id := range ids {
+			e := &SearchEntryMutable{TraceID: []byte{id}}
+			e.AddTag("key", fmt.Sprintf("value%d", id))
+			b.AddData(e)
+		}
+		return b.Finish()
+	}
+
+	merged, err := MergePages([][]byte{page(1, 2), page(), page(3)})
+	require.NoError(t, err)
+
+	p := GetRootAsSearchPage(merged, 0)
+	var ids []byte
+	ForeachEntry(p, func(e *SearchEntry) bool {
+		ids = append(ids, e.Id()...)
+		return true
+	})
+	require.Equal(t, []byte{1, 2, 3}, ids)
+
+	kv := FindTag(p, &KeyValues{}, []byte("key"))
+	require.NotNil(t, kv)
+	require.Equal(t, 3, kv.ValueLength())
+
+	_, err = MergePages([][]byte{page(1), {0x01}})
+	require.Error(t, err)
+
+	_, err = MergePages([][]byte{{0xFF, 0xFF, 0xFF, 0xFF, 0x00}})
+	require.Error(t, err)
+}
+
+func TestForeachTagKeyWithPrefix(t *testing.T) {
+	m := &SearchEntryMutable{}
+	for _, k := range []string{"a", "http", "http.method", "http.status_code", "http.url", "httpx", "k8s.pod", "z"} {
+		m.AddTag(k, "value")
+	}
+	e := NewSearchEntryFromBytes(m.ToBytes())
+
+	testCases := []struct {
+		prefix   string
+		expected []string
+	}{
+		{"http.", []string{"http.method", "http.status_code", "http.url"}},
+		{"http", []string{"http", "http.method", "http.status_code", "http.url", "httpx"}},
+		{"", []string{"a", "http", "http.method", "http.status_code", "http.url", "httpx", "k8s.pod", "z"}},
+		{"z", []string{"z"}},
+		{"b", nil},
+		{"zz", nil},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.prefix, func(t *testing.T) {
+			var keys []string
+			ForeachTagKeyWithPrefix(e, &KeyValues{}, []byte(tc.prefix), func(kv *KeyValues) bool {
+				keys = append(keys, string(kv.Key()))
+				return true
+			})
+			require.Equal(t, tc.expected, keys)
+		})
+	}
+
+	// Stop early
+	var keys []string
+	ForeachTagKeyWithPrefix(e, &KeyValues{}, []byte("http."), func(kv *KeyValues) bool {
+		keys = append(keys, string(kv.Key()))
+		return false
+	})
+	require.Equal(t, []string{"http.method"}, keys)
+}
+
+func TestLowerBound(t *testing.T) {
+	values := []int{1, 3, 3, 5}
+
+	testCases := []struct {
+		target, expected int
+	}{
+		{0, 0},
+		{1, 0},
+		{2, 1},
+		{3, 1},
+		{4, 3},
+		{5, 3},
+		{6, 4},
+	}
+
+	for _, tc := range testCases {
+		t.Run(fmt.Sprint(tc.target), func(t *testing.T) {
+			require.Equal(t, tc.expected, lowerBound(len(values), func(i int) int {
+				return values[i] - tc.target
+			}))
+		})
+	}
+
+	require.Equal(t, 0, lowerBound(0, func(i int) int { return 0 }))
+}
+
+func TestBinarySearch(t *testing.T) {
+	// Descending like the flatbuffer data
+	values := []int{50, 30, 10}
+
+	for _, tc := range []struct {
+		target, expected int
+	}{
+		{50, 0},
+		{30, 1},
+		{10, 2},
+		{0, -1},
+		{20, -1},
+		{60, -1},
+	} {
+		t.Run(fmt.Sprint(tc.target), func(t *testing.T) {
+			// Comparator returns magnitudes other than 1
+			require.Equal(t, tc.expected, binarySearch(len(values), func(i int) int {
+				return values[i] - tc.target
+			}))
+		})
+	}
+}
+
+func TestSearchPageBuilderAddDataBatch(t *testing.T) {
+	var entries []*SearchEntryMutable
+	for i := 0; i < 10; i++ {
+		e := &SearchEntryMutable{TraceID: []byte{byte(i)}, StartTimeUnixNano: uint64(i)}
+		e.AddTag("key", "value")
+		e.AddTag(fmt.Sprintf("key%d", i), fmt.Sprintf("value%d", i))
+		entries = append(entries, e)
+	}
+
+	opts := SearchPageBuilderOpts{MaxTagsPerEntry: 1, MaxDistinctTagsPerPage: 5}
+
+	single := NewSearchPageBuilderWithOpts(opts)
+	singleBytes := 0
+	for _, e := range entries {
+		singleBytes += single.AddData(e)
+	}
+
+	batch := NewSearchPageBuilderWithOpts(opts)
+	batchBytes := batch.AddDataBatch(entries)
+
+	require.Equal(t, singleBytes, batchBytes)
+	require.Equal(t, single.DroppedTags(), batch.DroppedTags())
+	require.Equal(t, single.Finish(), batch.Finish())
+}
+
+func BenchmarkSearchPageBuilder(b *testing.B) {
+	var entries []*SearchEntryMutable
+	for i := 0; i < 1000; i++ {
+		e := &SearchEntryMutable{TraceID: []byte(fmt.Sprintf("%016d", i))}
+		for j := 0; j < 10; j++ {
+			e.AddTag(fmt.Sprintf("key%d", j), fmt.Sprintf("value%d", i%100))
+		}
+		entries = append(entries, e)
+	}
+
+	size := func() int {
+		sb := NewSearchPageBuilder()
+		sb.AddDataBatch(entries)
+		return len(sb.Finish())
+	}()
+
+	b.Run("default", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			sb := NewSearchPageBuilder()
+			for _, e := range entries {
+				sb.AddData(e)
+			}
+			sb.Finish()
+		}
+	})
+
+	b.Run("sized", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			sb := NewSearchPageBuilderWithOpts(SearchPageBuilderOpts{
+				ExpectedEntries: len(entries),
+				ExpectedBytes:   size,
+			})
+			for _, e := range entries {
+				sb.AddData(e)
+			}
+			sb.Finish()
+		}
+	})
+}
+
+func TestCountMatching(t *testing.T) {
+	b := NewSearchPageBuilder()
+	for i := 0; i < 10; i++ {
+		e := &SearchEntryMutable{StartTimeUnixNano: uint64(i * 10), EndTimeUnixNano: uint64(i*10 + 5)}
+		e.AddTag("service", fmt.Sprintf("svc%d", i%2))
+		e.AddTag("env", "prod")
+		b.AddData(e)
+	}
+	page := GetRootAsSearchPage(b.Finish(), 0)
+
+	kv := &KeyValues{}
+	pairs := []TagPair{
+		{[]byte("service"), []byte("svc1")},
+		{[]byte("env"), []byte("prod")},
+	}
+
+	require.Equal(t, 10, CountMatching(page, func(e *SearchEntry) bool { return true }))
+	require.Equal(t, 5, CountMatching(page, func(e *SearchEntry) bool { return e.ContainsAll(pairs, kv) }))
+	require.Equal(t, 2, CountMatching(page, func(e *SearchEntry) bool {
+		return e.Overlaps(0, 40) && e.ContainsAll(pairs, kv)
+	}))
+}
+
+func TestMatchingTraceIDs(t *testing.T) {
+	b := NewSearchPageBuilder()
+	for i := 0; i < 10; i++ {
+		b.AddData(&SearchEntryMutable{TraceID: []byte{byte(i)}})
+	}
+	buf := b.Finish()
+	page := GetRootAsSearchPage(buf, 0)
+
+	even := func(e *SearchEntry) bool { return e.Id()[0]%2 == 0 }
+
+	ids := MatchingTraceIDs(page, even, 0)
+	require.Equal(t, []common.ID{{0}, {2}, {4}, {6}, {8}}, ids)
+
+	require.Equal(t, []common.ID{{0}, {2}}, MatchingTraceIDs(page, even, 2))
+
+	// Not aliased
+	for i := range buf {
+		buf[i] = 0xFF
+	}
+	require.Equal(t, common.ID{8}, ids[4])
+}
+
+func TestDistinctMatchingTraceIDs(t *testing.T) {
+	b := NewSearchPageBuilder()
+	for _, id := range []byte{1, 2, 1, 3, 2, 4} {
+		b.AddData(&SearchEntryMutable{TraceID: []byte{id}})
+	}
+	page := GetRootAsSearchPage(b.Finish(), 0)
+
+	all := func(e *SearchEntry) bool { return true }
+
+	require.Len(t, MatchingTraceIDs(page, all, 0), 6)
+	require.Equal(t, []common.ID{{1}, {2}, {3}, {4}}, DistinctMatchingTraceIDs(page, all, 0))
+	require.Equal(t, []common.ID{{1}, {2}, {3}}, DistinctMatchingTraceIDs(page, all, 3))
+}
+
+func TestSearchPageBuilderSortEntriesByStartTime(t *testing.T) {
+	b := NewSearchPageBuilderWithOpts(SearchPageBuilderOpts{SortEntriesByStartTime: true})
+	for _, start := range []uint64{30, 10, 50, 20, 40, 20} {
+		b.AddData(&SearchEntryMutable{TraceID: []byte{byte(start)}, StartTimeUnixNano: start})
+	}
+	page := GetRootAsSearchPage(b.Finish(), 0)
+
+	var starts []uint64
+	ForeachEntry(page, func(e *SearchEntry) bool {
+		starts = append(starts, e.StartTimeUnixNano())
+		return true
+	})
+	require.Equal(t, []uint64{10, 20, 20, 30, 40, 50}, starts)
+
+	testCases := []struct {
+		start    uint64
+		expected int
+	}{
+		{0, 0},
+		{10, 0},
+		{11, 1},
+		{20, 1},
+		{21, 3},
+		{50, 5},
+		{51, 6},
+	}
+	for _, tc := range testCases {
+		require.Equal(t, tc.expected, FindFirstEntryAfter(page, tc.start), "start=%d", tc.start)
+	}
+
+	// Reuse after reset
+	b.Reset()
+	b.AddData(&SearchEntryMutable{StartTimeUnixNano: 2})
+	b.AddData(&SearchEntryMutable{StartTimeUnixNano: 1})
+	page = GetRootAsSearchPage(b.Finish(), 0)
+	require.Equal(t, 1, FindFirstEntryAfter(page, 2))
+}
+
+func TestPageTimeBounds(t *testing.T) {
+	page := func(entries ...*SearchEntryMutable) *SearchPage {
+		b := NewSearchPageBuilder()
+		for _, e := range entries {
+			b.AddData(e)
+		}
+		return GetRootAsSearchPage(b.Finish(), 0)
+	}
+
+	testCases := []struct {
+		name             string
+		page             *SearchPage
+		minStart, maxEnd uint64
+	}{
+		{"empty", page(), 0, 0},
+		{"single", page(&SearchEntryMutable{StartTimeUnixNano: 10, EndTimeUnixNano: 20}), 10, 20},
+		{"multiple", page(
+			&SearchEntryMutable{StartTimeUnixNano: 30, EndTimeUnixNano: 40},
+			&SearchEntryMutable{StartTimeUnixNano: 10, EndTimeUnixNano: 20},
+		), 10, 40},
+		{"zero start", page(
+			&SearchEntryMutable{StartTimeUnixNano: 30, EndTimeUnixNano: 40},
+			&SearchEntryMutable{EndTimeUnixNano: 20},
+		), 0, 40},
+		{"open-ended", page(
+			&SearchEntryMutable{StartTimeUnixNano: 30, EndTimeUnixNano: 40},
+			&SearchEntryMutable{StartTimeUnixNano: 10},
+		), 10, 0},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			minStart, maxEnd := PageTimeBounds(tc.page)
+			require.Equal(t, tc.minStart, minStart)
+			require.Equal(t, tc.maxEnd, maxEnd)
+		})
+	}
+}
+
+func TestFindTagValueIndex(t *testing.T) {
+	m := &SearchEntryMutable{}
+	m.AddTag("status", "503")
+	m.AddTag("status", "200")
+	m.AddTag("status", "404")
+
+	e := NewSearchEntryFromBytes(m.ToBytes())
+	kv := &KeyValues{}
+
+	// Indexes are in ascending order, like GetAll
+	values := e.GetAll("status")
+	for v, expected := range map[string]int{"200": 0, "404": 1, "503": 2, "40": 1, "0": 0} {
+		idx, found := FindTagValueIndex(e, kv, []byte("status"), []byte(v))
+		require.True(t, found)
+		require.Equal(t, expected, idx, v)
+		require.Equal(t, values[idx], string(valueAt(kv, idx)))
+	}
+
+	idx, found := FindTagValueIndex(e, kv, []byte("status"), []byte("500"))
+	require.False(t, found)
+	require.Equal(t, -1, idx)
+
+	idx, found = FindTagValueIndex(e, kv, []byte("missing"), []byte("200"))
+	require.False(t, found)
+	require.Equal(t, -1, idx)
+}
+
+func TestSearchEntryNotContains(t *testing.T) {
+	m := &SearchEntryMutable{}
+	m.AddTag("service.name", "checkout")
+	m.AddTag("service.name", "cart")
+
+	e := NewSearchEntryFromBytes(m.ToBytes())
+	kv := &KeyValues{}
+
+	testCases := []struct {
+		key, value string
+		expected   bool
+	}{
+		{"service.name", "checkout", false},
+		{"service.name", "cart", false},
+		{"service.name", "frontend", true},
+		{"missing", "checkout", true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(fmt.Sprint(tc.key, "!=", tc.value), func(t *testing.T) {
+			require.Equal(t, tc.expected, e.NotContains([]byte(tc.key), []byte(tc.value), kv))
+			require.Equal(t, !tc.expected, e.Contains([]byte(tc.key), []byte(tc.value), kv))
+		})
+	}
+}
+
+func TestContainsTagNumericInRange(t *testing.T) {
+	m := &SearchEntryMutable{}
+	m.AddTag("http.status_code", "503")
+	m.AddTag("http.status_code", "n/a")
+	m.AddTag("duration_ms", "12.5")
+
+	e := NewSearchEntryFromBytes(m.ToBytes())
+	kv := &KeyValues{}
+
+	testCases := []struct {
+		key      string
+		min, max float64
+		expected bool
+	}{
+		{"http.status_code", 500, 599, true},
+		{"http.status_code", 503, 503, true},
+		{"http.status_code", 200, 299, false},
+		{"duration_ms", 10, 20, true},
+		{"duration_ms", 12.6, 20, false},
+		{"missing", 0, 1000, false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(fmt.Sprint(tc.key, tc.min, tc.max), func(t *testing.T) {
+			require.Equal(t, tc.expected, e.ContainsNumericInRange([]byte(tc.key), tc.min, tc.max, kv))
+		})
+	}
+}
+
+func TestSearchEntryEqual(t *testing.T) {
+	base := func() *SearchEntryMutable {
+		return &SearchEntryMutable{TraceID: []byte{1, 2, 3}, StartTimeUnixNano: 10, EndTimeUnixNano: 20}
+	}
+
+	m := base()
+	m.AddTag("foo", "bar")
+	m.AddTag("foo", "baz")
+	m.AddTag("service.name", "svc")
+
+	// Same data, different insertion order
+	same := base()
+	same.AddTag("service.name", "svc")
+	same.AddTag("foo", "baz")
+	same.AddTag("foo", "bar")
+
+	e := NewSearchEntryFromBytes(m.ToBytes())
+	require.True(t, e.Equal(e))
+	require.True(t, e.Equal(NewSearchEntryFromBytes(same.ToBytes())))
+
+	testCases := map[string]func(m *SearchEntryMutable){
+		"trace ID":    func(m *SearchEntryMutable) { m.TraceID = []byte{1, 2, 4} },
+		"start":       func(m *SearchEntryMutable) { m.StartTimeUnixNano = 11 },
+		"end":         func(m *SearchEntryMutable) { m.EndTimeUnixNano = 21 },
+		"extra key":   func(m *SearchEntryMutable) { m.AddTag("other", "bar") },
+		"extra value": func(m *SearchEntryMutable) { m.AddTag("foo", "qux") },
+		"value":       func(m *SearchEntryMutable) { m.RemoveTagValue("foo", "baz"); m.AddTag("foo", "bay") },
+		"key":         func(m *SearchEntryMutable) { m.RemoveTag("service.name"); m.AddTag("service.nam", "svc") },
+	}
+
+	for name, modify := range testCases {
+		t.Run(name, func(t *testing.T) {
+			other := m.Clone()
+			modify(other)
+			o := NewSearchEntryFromBytes(other.ToBytes())
+			require.False(t, e.Equal(o))
+			require.False(t, o.Equal(e))
+		})
+	}
+}
+
+func TestSearchEntryFingerprint(t *testing.T) {
+	m := &SearchEntryMutable{TraceID: []byte{1, 2, 3}, StartTimeUnixNano: 10, EndTimeUnixNano: 20}
+	m.AddTag("foo", "bar")
+	m.AddTag("foo", "baz")
+	m.AddTag("service.name", "svc")
+
+	same := &SearchEntryMutable{TraceID: []byte{1, 2, 3}, StartTimeUnixNano: 10, EndTimeUnixNano: 20}
+	same.AddTag("service.name", "svc")
+	same.AddTag("foo", "baz")
+	same.AddTag("foo", "bar")
+
+	e := NewSearchEntryFromBytes(m.ToBytes())
+	require.Equal(t, e.Fingerprint(), NewSearchEntryFromBytes(same.ToBytes()).Fingerprint())
+	require.Equal(t, e.Fingerprint(), m.Fingerprint())
+	require.Equal(t, e.Fingerprint(), same.Fingerprint())
+
+	// Pairs can't be shifted between keys and values
+	a := &SearchEntryMutable{}
+	a.AddTag("ab", "c")
+	b := &SearchEntryMutable{}
+	b.AddTag("a", "bc")
+	require.NotEqual(t, a.Fingerprint(), b.Fingerprint())
+
+	other := m.Clone()
+	other.TraceID = []byte{1, 2, 4}
+	require.NotEqual(t, m.Fingerprint(), other.Fingerprint())
+
+	other = m.Clone()
+	other.AddTag("foo", "qux")
+	require.NotEqual(t, m.Fingerprint(), other.Fingerprint())
+}
+
+func TestTagCardinality(t *testing.T) {
+	b := NewSearchPageBuilder()
+	for i := 0; i < 10; i++ {
+		e := &SearchEntryMutable{}
+		e.AddTag("service.name", fmt.Sprint("svc", i%3))
+		e.AddTag("http.url", fmt.Sprint("/api/", i))
+		e.AddTag("env", "prod")
+		b.AddData(e)
+	}
+
+	page := GetRootAsSearchPage(b.Finish(), 0)
+	require.Equal(t, map[string]int{
+		"service.name": 3,
+		"http.url":     10,
+		"env":          1,
+	}, TagCardinality(page))
+
+	require.Empty(t, TagCardinality(GetRootAsSearchPage(NewSearchPageBuilder().Finish(), 0)))
+
+	// Pages over MaxDistinctTagsPerPage have no page-level tags and are scanned instead
+	b = NewSearchPageBuilderWithOpts(SearchPageBuilderOpts{MaxDistinctTagsPerPage: 5})
+	for i := 0; i < 10; i++ {
+		e := &SearchEntryMutable{}
+		e.AddTag("service.name", fmt.Sprint("svc", i%3))
+		e.AddTag("http.url", fmt.Sprint("/api/", i))
+		b.AddData(e)
+	}
+	page = GetRootAsSearchPage(b.Finish(), 0)
+	require.False(t, page.HasTags())
+	require.Equal(t, map[string]int{
+		"service.name": 3,
+		"http.url":     10,
+	}, TagCardinality(page))
+}
+
+func TestSearchEntryExistenceTag(t *testing.T) {
+	m := &SearchEntryMutable{}
+	m.AddTag("error", "")
+	m.AddTag("foo", "bar")
+
+	e := NewSearchEntryFromBytes(m.ToBytes())
+	kv := &KeyValues{}
+
+	// Empty value matches key existence
+	require.True(t, e.Contains([]byte("error"), nil, kv))
+	require.True(t, e.Contains([]byte("foo"), nil, kv))
+	require.False(t, e.Contains([]byte("missing"), nil, kv))
+
+	// Existence tag has a single empty value
+	require.True(t, e.HasTag("error"))
+	require.Equal(t, []string{""}, e.GetAll("error"))
+	require.False(t, e.Contains([]byte("error"), []byte("bar"), kv))
+
+	page := NewSearchPageBuilder()
+	page.AddData(m)
+	require.True(t, PageContains(GetRootAsSearchPage(page.Finish(), 0), []byte("error"), nil, kv))
+}
+
+func TestSearchEntryGetOK(t *testing.T) {
+	m := &SearchEntryMutable{}
+	m.AddTag("error", "")
+	m.AddTag("foo", "bar")
+
+	e := NewSearchEntryFromBytes(m.ToBytes())
+
+	testCases := []struct {
+		key, value string
+		found      bool
+	}{
+		{"foo", "bar", true},
+		{"FOO", "bar", true},
+		{"error", "", true},
+		{"missing", "", false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.key, func(t *testing.T) {
+			v, ok := e.GetOK(tc.key)
+			require.Equal(t, tc.value, v)
+			require.Equal(t, tc.found, ok)
+			require.Equal(t, tc.value, e.Get(tc.key))
+		})
+	}
+}
+
+func TestSearchEntryContainsAnyValue(t *testing.T) {
+	m := &SearchEntryMutable{}
+	m.AddTag("service.name", "checkout")
+	m.AddTag("service.name", "cart")
+
+	e := NewSearchEntryFromBytes(m.ToBytes())
+	kv := &KeyValues{}
+
+	values := func(vv ...string) [][]byte {
+		var b [][]byte
+		for _, v := range vv {
+			b = append(b, []byte(v))
+		}
+		return b
+	}
+
+	testCases := []struct {
+		key              string
+		values           [][]byte
+		substring, exact bool
+	}{
+		{"service.name", values("frontend", "cart"), true, true},
+		{"service.name", values("frontend", "check"), true, false},
+		{"service.name", values("frontend", "backend"), false, false},
+		{"service.name", nil, false, false},
+		{"missing", values("cart"), false, false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(fmt.Sprint(tc.key, tc.values), func(t *testing.T) {
+			require.Equal(t, tc.substring, e.ContainsAnyValue([]byte(tc.key), tc.values, kv))
+			require.Equal(t, tc.exact, e.ContainsAnyValueExact([]byte(tc.key), tc.values, kv))
+		})
+	}
+}
+
+func TestSearchEntryContainsExact(t *testing.T) {
+	m := &SearchEntryMutable{}
+	m.AddTag("env", "production-backup")
+
+	e := NewSearchEntryFromBytes(m.ToBytes())
+	kv := &KeyValues{}
+
+	require.True(t, e.Contains([]byte("env"), []byte("prod"), kv))
+	require.False(t, e.ContainsExact([]byte("env"), []byte("prod"), kv))
+	require.True(t, e.ContainsExact([]byte("env"), []byte("production-backup"), kv))
+	require.False(t, e.ContainsExact([]byte("missing"), []byte("production-backup"), kv))
+
+	m.AddTag("env", "prod")
+	e = NewSearchEntryFromBytes(m.ToBytes())
+	require.True(t, e.ContainsExact([]byte("env"), []byte("prod"), kv))
+}
+
+func TestSearchPageBuilderSkipBatchTags(t *testing.T) {
+	build := func(opts SearchPageBuilderOpts) []byte {
+		b := NewSearchPageBuilderWithOpts(opts)
+		for i := 0; i < 10; i++ {
+			e := &SearchEntryMutable{TraceID: []byte{byte(i)}}
+			e.AddTag("service.name", fmt.Sprint("svc", i))
+			b.AddData(e)
+		}
+		require.LessOrEqual(t, b.CurrentSize(), b.EstimatedTotalSize())
+		return b.Finish()
+	}
+
+	withTags := build(SearchPageBuilderOpts{})
+	withoutTags := build(SearchPageBuilderOpts{SkipBatchTags: true})
+	require.Less(t, len(withoutTags), len(withTags))
+
+	kv := &KeyValues{}
+
+	page := GetRootAsSearchPage(withTags, 0)
+	require.True(t, page.HasTags())
+	require.False(t, PageContains(page, []byte("service.name"), []byte("svc10"), kv))
+
+	// Without page-level tags the page can't be excluded, but entries are still searchable
+	page = GetRootAsSearchPage(withoutTags, 0)
+	require.False(t, page.HasTags())
+	require.Zero(t, page.TagsLength())
+	require.True(t, PageContains(page, []byte("service.name"), []byte("svc10"), kv))
+	require.True(t, page.Contains([]byte("service.name"), []byte("svc10"), kv))
+	require.Equal(t, 1, CountMatching(page, func(e *SearchEntry) bool {
+		return e.Contains([]byte("service.name"), []byte("svc3"), kv)
+	}))
+
+	// Empty pages still have page-level tags
+	require.True(t, GetRootAsSearchPage(NewSearchPageBuilder().Finish(), 0).HasTags())
+}
+
+func TestAppendToPage(t *testing.T) {
+	entry := func(id byte) *SearchEntryMutable {
+		e := &SearchEntryMutable{TraceID: []byte{id}, StartTimeUnixNano: uint64(id), EndTimeUnixNano: uint64(id) + 1}
+		e.AddTag("key", fmt.Sprintf("value%d", id))
+		e.AddTag("key", "shared")
+		e.AddTag("other", "x")
+		return e
+	}
+
+	b := NewSearchPageBuilder()
+	b.AddData(entry(1))
+	b.AddData(entry(2))
+	existing := b.Finish()
+
+	appended, err := AppendToPage(existing, []*SearchEntryMutable{entry(3)})
+	require.NoError(t, err)
+
+	// Same result as building the page from scratch
+	b = NewSearchPageBuilder()
+	b.AddDataBatch([]*SearchEntryMutable{entry(1), entry(2), entry(3)})
+	expected := GetRootAsSearchPage(b.Finish(), 0)
+	p := GetRootAsSearchPage(appended, 0)
+
+	require.Equal(t, expected.EntriesLength(), p.EntriesLength())
+	c1, c2 := NewEntryCursor(expected), NewEntryCursor(p)
+	for i := 0; i < c1.Len(); i++ {
+		require.True(t, c1.Entry(i).Equal(c2.Entry(i)))
+	}
+	require.Equal(t, TagCardinality(expected), TagCardinality(p))
+	require.True(t, PageContains(p, []byte("key"), []byte("value1"), &KeyValues{}))
+	require.True(t, PageContains(p, []byte("key"), []byte("value3"), &KeyValues{}))
+
+	// Pages without page-level tags stay that way
+	b = NewSearchPageBuilderWithOpts(SearchPageBuilderOpts{SkipBatchTags: true})
+	b.AddData(entry(1))
+	appended, err = AppendToPage(b.Finish(), []*SearchEntryMutable{entry(2)})
+	require.NoError(t, err)
+	p = GetRootAsSearchPage(appended, 0)
+	require.False(t, p.HasTags())
+	require.Equal(t, 2, p.EntriesLength())
+
+	_, err = AppendToPage([]byte{0x01}, nil)
+	require.Error(t, err)
+
+	_, err = AppendToPage([]byte{0xFF, 0xFF, 0xFF, 0xFF, 0x00}, nil)
+	require.Error(t, err)
+}
+
+func TestSearchEntryAppendTraceID(t *testing.T) {
+	b := NewSearchPageBuilder()
+	for i := 0; i < 3; i++ {
+		b.AddData(&SearchEntryMutable{TraceID: []byte{byte(i), byte(i)}})
+	}
+	buf := b.Finish()
+
+	var ids []byte
+	ForeachEntry(GetRootAsSearchPage(buf, 0), func(e *SearchEntry) bool {
+		ids = e.AppendTraceID(ids)
+		return true
+	})
+	require.Equal(t, []byte{0, 0, 1, 1, 2, 2}, ids)
+
+	// Not aliased
+	for i := range buf {
+		buf[i] = 0xFF
+	}
+	require.Equal(t, []byte{0, 0, 1, 1, 2, 2}, ids)
+}
+
+func TestSearchEntryMutableAddTags(t *testing.T) {
+	expected := &SearchEntryMutable{}
+	expected.AddTag("foo", "bar")
+	expected.AddTag("foo", "baz")
+	expected.AddTag("service.name", "svc")
+
+	m := &SearchEntryMutable{}
+	m.AddTags(map[string][]string{
+		"foo":          {"bar", "baz", "bar"},
+		"service.name": {"svc"},
+	})
+	require.Equal(t, expected.Tags.ToMap(), m.Tags.ToMap())
+
+	m = &SearchEntryMutable{}
+	m.AddTag("foo", "bar")
+	m.AddTagsSingle(map[string]string{
+		"foo":          "baz",
+		"service.name": "svc",
+	})
+	require.Equal(t, expected.Tags.ToMap(), m.Tags.ToMap())
+}
+
+func TestSearchEntryMutableKeyNormalizer(t *testing.T) {
+	normalizer := func(k string) string {
+		if k == "http.request.method" {
+			return "http.method"
+		}
+		return k
+	}
+
+	m := &SearchEntryMutable{KeyNormalizer: normalizer}
+	m.AddTag("http.request.method", "GET")
+	m.AddTag("http.method", "POST")
+	m.AddTags(map[string][]string{"http.request.method": {"PUT"}})
+	m.AddTagsSingle(map[string]string{"http.request.method": "GET", "service.name": "svc"})
+
+	require.Equal(t, map[string][]string{
+		"http.method":  {"GET", "POST", "PUT"},
+		"service.name": {"svc"},
+	}, m.Tags.ToMap())
+
+	c := m.Clone()
+	c.RemoveTagValue("http.request.method", "PUT")
+	c.RemoveTag("service.name")
+	require.Equal(t, map[string][]string{
+		"http.method": {"GET", "POST"},
+	}, c.Tags.ToMap())
+
+	// Default is unchanged
+	m = &SearchEntryMutable{}
+	m.AddTag("http.request.method", "GET")
+	require.Equal(t, map[string][]string{"http.request.method": {"GET"}}, m.Tags.ToMap())
+}
+
+func TestSearchPageBuilderStats(t *testing.T) {
+	b := NewSearchPageBuilderWithOpts(SearchPageBuilderOpts{MaxTagsPerEntry: 2, MaxDistinctTagsPerPage: 3})
+	require.Equal(t, SearchPageBuilderStats{}, b.Stats())
+
+	bytesWritten := 0
+	for i := 0; i < 2; i++ {
+		e := &SearchEntryMutable{TraceID: []byte{byte(i)}}
+		e.AddTag("a", fmt.Sprint(i))
+		e.AddTag("b", fmt.Sprint(i))
+		e.AddTag("c", fmt.Sprint(i))
+		bytesWritten += b.AddData(e)
+	}
+
+	require.Equal(t, SearchPageBuilderStats{
+		EntriesAdded:    2,
+		TagsAdded:       4,
+		TagsDropped:     2,
+		PageTagsDropped: 1,
+		BytesWritten:    int64(bytesWritten),
+	}, b.Stats())
+	require.Equal(t, 2, b.DroppedTags())
+
+	// Cumulative across pages
+	b.Finish()
+	b.Reset()
+	b.AddData(&SearchEntryMutable{})
+	require.Equal(t, int64(3), b.Stats().EntriesAdded)
+}
+
+func TestSearchPageBuilderReset(t *testing.T) {
+	add := func(b *SearchPageBuilder, i int) {
+		e := &SearchEntryMutable{TraceID: []byte{byte(i)}}
+		e.AddTag("key", fmt.Sprint("value", i))
+		b.AddData(e)
+	}
+
+	b := NewSearchPageBuilder()
+	add(b, 1)
+	add(b, 2)
+	b.Finish()
+	b.Reset()
+	require.Zero(t, b.allTags.Len())
+	add(b, 3)
+
+	fresh := NewSearchPageBuilder()
+	add(fresh, 3)
+	require.Equal(t, fresh.Finish(), b.Finish())
+}
+
+func BenchmarkSearchPageBuilderReset(b *testing.B) {
+	var entries []*SearchEntryMutable
+	for i := 0; i < 10; i++ {
+		e := &SearchEntryMutable{TraceID: []byte(fmt.Sprintf("%016d", i))}
+		for j := 0; j < 10; j++ {
+			e.AddTag(fmt.Sprintf("key%d", j), fmt.Sprintf("value%d", i))
+		}
+		entries = append(entries, e)
+	}
+
+	b.ReportAllocs()
+	sb := NewSearchPageBuilder()
+	for i := 0; i < b.N; i++ {
+		sb.Reset()
+		for _, e := range entries {
+			sb.AddData(e)
+		}
+		sb.Finish()
+	}
+}
+
+func TestSearchEntryMixedCaseKeys(t *testing.T) {
+	m := &SearchEntryMutable{}
+	m.AddTag("HTTP.Method", "GET")
+	m.AddTag("http.method", "get")
+	m.AddTag("http.method", "POST")
+	m.AddTag("B", "x")
+	m.AddTag("a", "y")
+
+	e := NewSearchEntryFromBytes(m.ToBytes())
+	kv := &KeyValues{}
+
+	// Keys which only differ in case are merged, and stay sorted after lowercasing
+	require.Equal(t, []string{"a", "b", "http.method"}, e.Keys())
+	require.Equal(t, []string{"get", "post"}, e.GetAll("http.method"))
+	require.Equal(t, []string{"get", "post"}, e.GetAll("HTTP.Method"))
+	require.Equal(t, "x", e.Get("b"))
+	require.Equal(t, "y", e.Get("A"))
+	require.True(t, e.ContainsExact([]byte("http.method"), []byte("post"), kv))
+	require.True(t, e.Contains([]byte("b"), []byte("x"), kv))
+}
+
+func TestForeachTag(t *testing.T) {
+	m := &SearchEntryMutable{}
+	m.AddTag("b", "2")
+	m.AddTag("b", "1")
+	m.AddTag("a", "1")
+	m.AddTag("c", "1")
+
+	e := NewSearchEntryFromBytes(m.ToBytes())
+
+	var pairs []string
+	ForeachTag(e, &KeyValues{}, func(key, value []byte) bool {
+		pairs = append(pairs, string(key)+"="+string(value))
+		return true
+	})
+	require.Equal(t, []string{"a=1", "b=1", "b=2", "c=1"}, pairs)
+
+	// Stops early
+	pairs = nil
+	ForeachTag(e, &KeyValues{}, func(key, value []byte) bool {
+		pairs = append(pairs, string(key)+"="+string(value))
+		return len(pairs) < 2
+	})
+	require.Equal(t, []string{"a=1", "b=1"}, pairs)
+}
+
+func TestBuildInvertedIndex(t *testing.T) {
+	b := NewSearchPageBuilder()
+	for i := 0; i < 4; i++ {
+		e := &SearchEntryMutable{TraceID: []byte{byte(i)}}
+		e.AddTag("service.name", fmt.Sprint("svc", i%2))
+		e.AddTag("env", "prod")
+		b.AddData(e)
+	}
+	buf := b.Finish()
+
+	index := BuildInvertedIndex(GetRootAsSearchPage(buf, 0))
+	expected := map[string]map[string][]common.ID{
+		"service.name": {
+			"svc0": {{0}, {2}},
+			"svc1": {{1}, {3}},
+		},
+		"env": {
+			"prod": {{0}, {1}, {2}, {3}},
+		},
+	}
+	require.Equal(t, expected, index)
+
+	// Not aliased
+	for i := range buf {
+		buf[i] = 0
+	}
+	require.Equal(t, expected, index)
+
+	require.Empty(t, BuildInvertedIndex(GetRootAsSearchPage(NewSearchPageBuilder().Finish(), 0)))
+}
+
+func TestTagValueLengths(t *testing.T) {
+	m := &SearchEntryMutable{}
+	m.AddTag("trace.id", "0123456789abcdef")
+	m.AddTag("trace.id", "0123")
+	m.AddTag("error", "")
+
+	e := NewSearchEntryFromBytes(m.ToBytes())
+	kv := &KeyValues{}
+
+	// In ascending value order, "0123" < "0123456789abcdef"
+	require.Equal(t, []int{4, 16}, TagValueLengths(e, kv, []byte("trace.id")))
+	require.Equal(t, []int{0}, TagValueLengths(e, kv, []byte("error")))
+	require.Nil(t, TagValueLengths(e, kv, []byte("missing")))
+
+	lengths := TagValueLengths(e, kv, []byte("trace.id"))
+	for j, l := range lengths {
+		require.Len(t, valueAt(kv, j), l)
+	}
+}
+
+func TestTagsToDataMap(t *testing.T) {
+	m := &SearchEntryMutable{}
+	m.AddTag("foo", "bar")
+	m.AddTag("foo", "baz")
+	m.AddTag("service.name", "svc")
+
+	buf := m.ToBytes()
+	e := NewSearchEntryFromBytes(buf)
+	tags := TagsToDataMap(e)
+	require.Equal(t, m.Tags.ToMap(), tags.ToMap())
+
+	// Serializes to the same tags
+	require.Equal(t, buf, (&SearchEntryMutable{Tags: tags}).ToBytes())
+
+	// Not aliased
+	for i := range buf {
+		buf[i] = 0
+	}
+	require.Equal(t, m.Tags.ToMap(), tags.ToMap())
+}
+
+func TestSearchPageBuilderRejectDuplicateTraceIDs(t *testing.T) {
+	entry := func(id byte) *SearchEntryMutable {
+		return &SearchEntryMutable{TraceID: []byte{id}}
+	}
+
+	b := NewSearchPageBuilderWithOpts(SearchPageBuilderOpts{RejectDuplicateTraceIDs: true})
+	n, err := b.AddDataChecked(entry(1))
+	require.NoError(t, err)
+	require.Positive(t, n)
+
+	n, err = b.AddDataChecked(entry(1))
+	require.ErrorIs(t, err, ErrDuplicateTraceID)
+	require.Zero(t, n)
+
+	require.Zero(t, b.AddData(entry(1)))
+	b.AddDataBatch([]*SearchEntryMutable{entry(1), entry(2), entry(2)})
+	require.Equal(t, 2, b.EntryCount())
+	require.Equal(t, int64(4), b.Stats().DuplicateTraceIDsRejected)
+
+	// Tracked per page
+	b.Finish()
+	b.Reset()
+	_, err = b.AddDataChecked(entry(1))
+	require.NoError(t, err)
+
+	// Off by default
+	b = NewSearchPageBuilder()
+	for i := 0; i < 2; i++ {
+		_, err = b.AddDataChecked(entry(1))
+		require.NoError(t, err)
+	}
+	require.Equal(t, 2, b.EntryCount())
+}
+
+func TestSearchPageInfo(t *testing.T) {
+	b := NewSearchPageBuilder()
+	for i := 1; i <= 3; i++ {
+		e := &SearchEntryMutable{StartTimeUnixNano: uint64(i * 10), EndTimeUnixNano: uint64(i*10 + 5)}
+		e.AddTag("foo", "bar")
+		b.AddData(e)
+	}
+	buf := b.Finish()
+
+	info, err := SearchPageInfo(buf)
+	require.NoError(t, err)
+	require.Equal(t, PageInfo{Entries: 3, MinStart: 10, MaxEnd: 35, Size: len(buf)}, info)
+
+	_, err = SearchPageInfo([]byte{0x01})
+	require.Error(t, err)
+
+	_, err = SearchPageInfo([]byte{0xFF, 0xFF, 0xFF, 0xFF, 0x00})
+	require.Error(t, err)
+
+	// Tags aren't read
+	corruptVectorLength(GetRootAsSearchPage(buf, 0)._tab, 4)
+	_, err = NewSearchPageFromBytesSafe(buf)
+	require.Error(t, err)
+	info, err = SearchPageInfo(buf)
+	require.NoError(t, err)
+	require.Equal(t, 3, info.Entries)
+
+	// Entries out of bounds
+	corruptVectorLength(GetRootAsSearchPage(buf, 0)._tab, 6)
+	_, err = SearchPageInfo(buf)
+	require.Error(t, err)
+}
+
+func TestSearchPageReset(t *testing.T) {
+	pages := make([][]byte, 3)
+	for i := range pages {
+		b := NewSearchPageBuilder()
+		for j := 0; j <= i; j++ {
+			b.AddData(&SearchEntryMutable{TraceID: []byte{byte(i), byte(j)}})
+		}
+		pages[i] = b.Finish()
+	}
+
+	ids := func(page *SearchPage) (ids [][]byte) {
+		ForeachEntry(page, func(e *SearchEntry) bool {
+			ids = append(ids, e.Id())
+			return true
+		})
+		return ids
+	}
+
+	page := &SearchPage{}
+	for _, buf := range pages {
+		page.Reset(buf)
+		require.Equal(t, ids(GetRootAsSearchPage(buf, 0)), ids(page))
+	}
+}
+
+func TestSearchEntryValueType(t *testing.T) {
+	s := &SearchEntryMutable{TraceID: []byte{1}}
+	s.AddTag("http.method", "GET")
+	s.AddTagTyped("http.status_code", "200", ValueTypeInt)
+	s.AddTagTyped("http.status_code", "500", ValueTypeInt)
+	s.AddTagTyped("http.status_code", "ok", ValueTypeString)
+	s.AddTagTyped("error", "true", ValueTypeBool)
+
+	valueTypes := func(e *SearchEntry, k string) map[string]ValueType {
+		m := map[string]ValueType{}
+		for j, v := range e.GetAll(k) {
+			m[v] = e.ValueType([]byte(k), j)
+		}
+		return m
+	}
+
+	e := GetRootAsSearchEntry(s.ToBytes(), 0)
+	require.Equal(t, map[string]ValueType{"get": ValueTypeString}, valueTypes(e, "http.method"))
+	require.Equal(t, map[string]ValueType{"200": ValueTypeInt, "500": ValueTypeInt, "ok": ValueTypeString}, valueTypes(e, "http.status_code"))
+	require.Equal(t, map[string]ValueType{"true": ValueTypeBool}, valueTypes(e, "error"))
+	require.Equal(t, ValueTypeString, e.ValueType([]byte("missing"), 0))
+
+	// Untyped tags are written without the type vector
+	require.Equal(t, 0, FindTag(e, &KeyValues{}, []byte("http.method")).ValueTypeLength())
+
+	// Types survive the mutable form and copying into pages
+	require.Equal(t, s.ToBytes(), FromSearchEntry(e).ToBytes())
+	require.Equal(t, s.ToBytes(), s.Clone().ToBytes())
+
+	page, err := AppendToPage(NewSearchPageBuilder().Finish(), []*SearchEntryMutable{s})
+	require.NoError(t, err)
+	page, err = AppendToPage(page, nil)
+	require.NoError(t, err)
+	ForeachEntry(GetRootAsSearchPage(page, 0), func(e *SearchEntry) bool {
+		require.Equal(t, map[string]ValueType{"true": ValueTypeBool}, valueTypes(e, "error"))
+		return true
+	})
+
+	// Indexes pair with GetAll
+	s = &SearchEntryMutable{}
+	s.AddTag("k", "a")
+	s.AddTag("k", "b")
+	s.AddTagTyped("k", "c", ValueTypeInt)
+	e = GetRootAsSearchEntry(s.ToBytes(), 0)
+	require.Equal(t, []string{"a", "b", "c"}, e.GetAll("k"))
+	require.Equal(t, ValueTypeString, e.ValueType([]byte("k"), 0))
+	require.Equal(t, ValueTypeString, e.ValueType([]byte("k"), 1))
+	require.Equal(t, ValueTypeInt, e.ValueType([]byte("k"), 2))
+	require.Equal(t, ValueTypeString, e.ValueType([]byte("k"), 3))
+
+	// Removing a value drops its type
+	s = &SearchEntryMutable{}
+	s.AddTagTyped("error", "true", ValueTypeBool)
+	s.RemoveTagValue("error", "true")
+	s.AddTag("error", "true")
+	e = GetRootAsSearchEntry(s.ToBytes(), 0)
+	require.Equal(t, ValueTypeString, e.ValueType([]byte("error"), 0))
+}
+
+func TestSplitEntry(t *testing.T) {
+	e := &SearchEntryMutable{
+		TraceID:           []byte{1, 2},
+		StartTimeUnixNano: 10,
+		EndTimeUnixNano:   20,
+	}
+	for i := 0; i < 5; i++ {
+		e.AddTag("key", fmt.Sprintf("value%d", i))
+	}
+	e.AddTagTyped("status", "200", ValueTypeInt)
+
+	require.Equal(t, []*SearchEntryMutable{e}, SplitEntry(e, 0))
+	require.Equal(t, []*SearchEntryMutable{e}, SplitEntry(e, 6))
+
+	entries := SplitEntry(e, 4)
+	require.Len(t, entries, 2)
+
+	merged := &SearchEntryMutable{TraceID: e.TraceID}
+	for _, split := range entries {
+		require.Equal(t, e.TraceID, split.TraceID)
+		require.Equal(t, e.StartTimeUnixNano, split.StartTimeUnixNano)
+		require.Equal(t, e.EndTimeUnixNano, split.EndTimeUnixNano)
+		require.LessOrEqual(t, split.Tags.ValueCount(), 4)
+		require.NoError(t, merged.Merge(split))
+	}
+	require.Equal(t, e.ToBytes(), merged.ToBytes())
+
+	last := GetRootAsSearchEntry(entries[1].ToBytes(), 0)
+	require.Equal(t, ValueTypeInt, last.ValueType([]byte("status"), 0))
+
+	// Trace IDs are copied
+	e.TraceID[0] = 9
+	for _, split := range entries {
+		require.Equal(t, common.ID{1, 2}, split.TraceID)
+	}
+}
+
+func TestSearchPageBuilderRejectInvalidTraceIDLength(t *testing.T) {
+	id := []byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16}
+
+	b := NewSearchPageBuilderWithOpts(SearchPageBuilderOpts{RejectInvalidTraceIDLength: true, RejectDuplicateTraceIDs: true})
+	_, err := b.AddDataChecked(&SearchEntryMutable{TraceID: id})
+	require.NoError(t, err)
+	_, err = b.AddDataChecked(&SearchEntryMutable{TraceID: id})
+	require.Equal(t, ErrDuplicateTraceID, err)
+	_, err = b.AddDataChecked(&SearchEntryMutable{TraceID: []byte{1}})
+	require.Equal(t, ErrInvalidTraceIDLength, err)
+	require.Zero(t, b.AddDataBatch([]*SearchEntryMutable{{TraceID: id}, {TraceID: []byte{2}}}))
+
+	page := GetRootAsSearchPage(b.Finish(), 0)
+	require.Equal(t, 1, page.EntriesLength())
+	require.Equal(t, int64(2), b.Stats().InvalidTraceIDsRejected)
+
+	e := &SearchEntry{}
+	page.Entries(e, 0)
+	id16, ok := e.TraceID16()
+	require.True(t, ok)
+	require.Equal(t, id, id16[:])
+
+	b.Reset()
+	_, err = b.AddDataChecked(&SearchEntryMutable{TraceID: id})
+	require.NoError(t, err)
+
+	e = GetRootAsSearchEntry((&SearchEntryMutable{TraceID: []byte{1}}).ToBytes(), 0)
+	_, ok = e.TraceID16()
+	require.False(t, ok)
+}
+
+func TestSearchEntryContainsNormalized(t *testing.T) {
+	s := &SearchEntryMutable{}
+	s.AddTag("http.url", "https://Example.com/Path")
+	e := GetRootAsSearchEntry(s.ToBytes(), 0)
+	kv := &KeyValues{}
+
+	require.False(t, e.Contains([]byte("http.url"), []byte("EXAMPLE"), kv))
+	require.True(t, e.ContainsNormalized([]byte("http.url"), []byte("EXAMPLE"), kv))
+	require.True(t, e.ContainsNormalized([]byte("HTTP.URL"), []byte("/path"), kv))
+	require.True(t, e.ContainsNormalized([]byte("http.url"), nil, kv))
+	require.False(t, e.ContainsNormalized([]byte("http.url"), []byte("other"), kv))
+	require.False(t, e.ContainsNormalized([]byte("missing"), nil, kv))
+
+	// Stored values which aren't lowercase, e.g. from older writers
+	require.Equal(t, []byte("xabcä"), appendLowerBytes([]byte("x"), []byte("ABCÄ")))
+}
+
+func TestDistinctValuesForKey(t *testing.T) {
+	for _, skip := range []bool{false, true} {
+		b := NewSearchPageBuilderWithOpts(SearchPageBuilderOpts{SkipBatchTags: skip})
+		for _, v := range []string{"c", "a", "b", "a"} {
+			s := &SearchEntryMutable{TraceID: []byte(v)}
+			s.AddTag("key", v)
+			s.AddTag("other", "x")
+			b.AddData(s)
+		}
+		page := GetRootAsSearchPage(b.Finish(), 0)
+		require.Equal(t, !skip, page.HasTags())
+
+		require.Equal(t, []string{"a", "b", "c"}, DistinctValuesForKey(page, []byte("key"), 0))
+		require.Equal(t, []string{"a", "b"}, DistinctValuesForKey(page, []byte("key"), 2))
+		require.Equal(t, []string{"x"}, DistinctValuesForKey(page, []byte("other"), 5))
+		require.Empty(t, DistinctValuesForKey(page, []byte("missing"), 0))
+	}
+}
+
+func TestDistinctKeys(t *testing.T) {
+	for _, skip := range []bool{false, true} {
+		b := NewSearchPageBuilderWithOpts(SearchPageBuilderOpts{SkipBatchTags: skip})
+		for _, k := range []string{"c", "a", "b", "a"} {
+			s := &SearchEntryMutable{TraceID: []byte(k)}
+			s.AddTag(k, "value")
+			b.AddData(s)
+		}
+		page := GetRootAsSearchPage(b.Finish(), 0)
+
+		require.Equal(t, []string{"a", "b", "c"}, DistinctKeys(page, 0))
+		require.Equal(t, []string{"a", "b"}, DistinctKeys(page, 2))
+	}
+
+	require.Empty(t, DistinctKeys(GetRootAsSearchPage(NewSearchPageBuilder().Finish(), 0), 0))
+}
+
+func TestDistinctPaged(t *testing.T) {
+	var expectedKeys, expectedValues []string
+	for i := 0; i < 25; i++ {
+		expectedKeys = append(expectedKeys, fmt.Sprintf("key%02d", i))
+		expectedValues = append(expectedValues, fmt.Sprintf("value%02d", i))
+	}
+
+	for _, skip := range []bool{false, true} {
+		b := NewSearchPageBuilderWithOpts(SearchPageBuilderOpts{SkipBatchTags: skip})
+		for i := range expectedKeys {
+			s := &SearchEntryMutable{TraceID: []byte{byte(i)}}
+			s.AddTag(expectedKeys[i], "value")
+			s.AddTag("key", expectedValues[i])
+			b.AddData(s)
+		}
+		page := GetRootAsSearchPage(b.Finish(), 0)
+
+		for _, limit := range []int{1, 7, 25, 100} {
+			var keys, values []string
+			for cursor := ""; ; {
+				var batch []string
+				batch, cursor = DistinctKeysPaged(page, cursor, limit)
+				require.LessOrEqual(t, len(batch), limit)
+				keys = append(keys, batch...)
+				if cursor == "" {
+					break
+				}
+			}
+			for cursor := ""; ; {
+				var batch []string
+				batch, cursor = DistinctValuesForKeyPaged(page, []byte("key"), cursor, limit)
+				require.LessOrEqual(t, len(batch), limit)
+				values = append(values, batch...)
+				if cursor == "" {
+					break
+				}
+			}
+
+			require.Equal(t, append([]string{"key"}, expectedKeys...), keys)
+			require.Equal(t, expectedValues, values)
+		}
+
+		keys, next := DistinctKeysPaged(page, "", 2)
+		require.Equal(t, []string{"key", "key00"}, keys)
+		keys, next = DistinctKeysPaged(page, next, 2)
+		require.Equal(t, []string{"key01", "key02"}, keys)
+		require.NotEmpty(t, next)
+
+		keys, next = DistinctKeysPaged(page, "", 26)
+		require.Len(t, keys, 26)
+		require.Empty(t, next)
+
+		values, next := DistinctValuesForKeyPaged(page, []byte("missing"), "", 2)
+		require.Empty(t, values)
+		require.Empty(t, next)
+	}
+}
+
+func TestSearchPageBuilderSkipEmptyValues(t *testing.T) {
+	s := &SearchEntryMutable{TraceID: []byte{1}}
+	s.AddTag("empty", "")
+	s.AddTag("mixed", "")
+	s.AddTag("mixed", "value")
+	s.AddTag("key", "value")
+
+	for _, skip := range []bool{false, true} {
+		b := NewSearchPageBuilderWithOpts(SearchPageBuilderOpts{SkipEmptyValues: skip})
+		b.AddData(s)
+		b.AddDataBatch([]*SearchEntryMutable{s})
+		page := GetRootAsSearchPage(b.Finish(), 0)
+
+		kv := &KeyValues{}
+		require.Equal(t, !skip, page.Contains([]byte("empty"), nil, kv))
+		require.Equal(t, !skip, ContainsTagExact(page, kv, []byte("mixed"), nil))
+		require.True(t, page.Contains([]byte("mixed"), []byte("value"), kv))
+
+		ForeachEntry(page, func(e *SearchEntry) bool {
+			require.Equal(t, !skip, e.Contains([]byte("empty"), nil, kv))
+			require.Equal(t, !skip, e.ContainsExact([]byte("mixed"), nil, kv))
+			require.True(t, e.Contains([]byte("key"), []byte("value"), kv))
+			return true
+		})
+
+		if skip {
+			require.Equal(t, int64(4), b.Stats().EmptyValuesSkipped)
+		} else {
+			require.Zero(t, b.Stats().EmptyValuesSkipped)
+		}
+	}
+
+	// The caller's data is untouched
+	require.True(t, s.Tags.Contains("empty", ""))
+}
+
+func TestKeyValuesPool(t *testing.T) {
+	s := &SearchEntryMutable{}
+	s.AddTag("key", "value")
+	e := GetRootAsSearchEntry(s.ToBytes(), 0)
+
+	kv := GetKeyValues()
+	require.True(t, e.Contains([]byte("key"), []byte("value"), kv))
+	require.Equal(t, []byte("key"), kv.Key())
+
+	PutKeyValues(kv)
+	require.Nil(t, kv.Table().Bytes)
+
+	// Lookups which use the pool internally
+	require.Equal(t, "value", e.Get("key"))
+	require.Equal(t, []string{"value"}, e.GetAll("key"))
+	require.True(t, e.HasTag("key"))
+	require.False(t, e.HasTag("missing"))
+}
+
+func TestDistinctPagedEmpty(t *testing.T) {
+	for _, skip := range []bool{false, true} {
+		b := NewSearchPageBuilderWithOpts(SearchPageBuilderOpts{SkipBatchTags: skip})
+		s := &SearchEntryMutable{TraceID: []byte{1}}
+		for _, v := range []string{"", "a", "b"} {
+			s.AddTag("key", v)
+			s.AddTag(v, "value")
+		}
+		b.AddData(s)
+		page := GetRootAsSearchPage(b.Finish(), 0)
+
+		var values, keys []string
+		for cursor := ""; ; {
+			var batch []string
+			batch, cursor = DistinctValuesForKeyPaged(page, []byte("key"), cursor, 1)
+			require.Len(t, batch, 1)
+			values = append(values, batch...)
+			if cursor == "" {
+				break
+			}
+		}
+		for cursor := ""; ; {
+			var batch []string
+			batch, cursor = DistinctKeysPaged(page, cursor, 1)
+			require.Len(t, batch, 1)
+			keys = append(keys, batch...)
+			if cursor == "" {
+				break
+			}
+		}
+
+		require.Equal(t, []string{"", "a", "b"}, values)
+		require.Equal(t, []string{"", "a", "b", "key"}, keys)
+	}
+}
+
+func TestSearchPageBuilderEstimatedTagsSize(t *testing.T) {
+	// The running estimate must equal a walk of the page-level tags
+	walk := func(b *SearchPageBuilder) int {
+		size := tagsVectorOverhead
+		b.allTags.RangeKeys(func(k string) {
+			size += estimatedKeySize(k)
+			b.allTags.RangeKeyValues(k, func(v string) {
+				size += estimatedValueSize(v)
+			})
+		})
+		return size
+	}
+
+	for _, opts := range []SearchPageBuilderOpts{{}, {MaxDistinctTagsPerPage: 5}} {
+		b := NewSearchPageBuilderWithOpts(opts)
+		for i := 0; i < 10; i++ {
+			e := &SearchEntryMutable{TraceID: []byte{byte(i)}}
+			e.AddTag("service.name", fmt.Sprint("svc", i%3))
+			e.AddTag(fmt.Sprint("key", i%4), "value")
+			if i%2 == 0 {
+				b.AddData(e)
+			} else {
+				b.AddDataBatch([]*SearchEntryMutable{e})
+			}
+			require.Equal(t, walk(b), b.pageTagsSize+tagsVectorOverhead)
+			require.Equal(t, b.allTags.ValueCount(), b.pageTagsCount)
+		}
+
+		b.Reset()
+		require.Equal(t, walk(b), b.pageTagsSize+tagsVectorOverhead)
+		require.Zero(t, b.pageTagsCount)
+	}
+}
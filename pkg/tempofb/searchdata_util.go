@@ -2,25 +2,213 @@ package tempofb
 
 import (
 	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 
+	"github.com/cespare/xxhash"
 	flatbuffers "github.com/google/flatbuffers/go"
 	"github.com/grafana/tempo/tempodb/encoding/common"
 )
 
+// ErrMergeTraceIDMismatch is returned when merging search data that belongs to different traces.
+var ErrMergeTraceIDMismatch = errors.New("cannot merge search data of different trace ids")
+
+// ErrDuplicateTraceID is returned when adding an entry for a trace which is already in the page,
+// see RejectDuplicateTraceIDs.
+var ErrDuplicateTraceID = errors.New("duplicate trace id in search page")
+
+// ErrInvalidTraceIDLength is returned when adding an entry whose trace ID is not 16 bytes to a page
+// with RejectInvalidTraceIDLength.
+var ErrInvalidTraceIDLength = errors.New("invalid trace id length in search page")
+
 // SearchEntryMutable is a mutable form of the flatbuffer-compiled SearchEntry struct to make building and transporting easier.
 type SearchEntryMutable struct {
 	TraceID           common.ID
 	Tags              SearchDataMap
 	StartTimeUnixNano uint64
 	EndTimeUnixNano   uint64
+
+	// KeyNormalizer, if set, canonicalizes tag keys in AddTag, AddTags, AddTagsSingle, RemoveTag
+	// and RemoveTagValue, e.g. to map http.request.method to http.method. Values of keys which
+	// normalize to the same key are merged. Tags added to Tags directly or by Merge are not
+	// normalized.
+	KeyNormalizer func(string) string
+
+	// valueTypes holds the non-string types recorded by AddTagTyped, by key and value.
+	valueTypes map[string]map[string]ValueType
+}
+
+// NewSearchEntryMutable returns an empty entry with initialized tags. Combined with Reset it is suitable
+// as the constructor of a sync.Pool.
+func NewSearchEntryMutable() *SearchEntryMutable {
+	return &SearchEntryMutable{
+		Tags: NewSearchDataMap(),
+	}
 }
 
 // AddTag adds the unique tag name and value to the search data. No effect if the pair is already present.
+// An empty value is stored as-is, which records the existence of the key without any value.
 func (s *SearchEntryMutable) AddTag(k string, v string) {
 	if s.Tags == nil {
 		s.Tags = NewSearchDataMap()
 	}
-	s.Tags.Add(k, v)
+	s.Tags.Add(s.normalizeKey(k), v)
+}
+
+// AddTags adds all tag names and values, as if by calling AddTag for each pair.
+func (s *SearchEntryMutable) AddTags(m map[string][]string) {
+	if s.Tags == nil {
+		s.Tags = make(SearchDataMapLarge, len(m))
+	}
+	for k, vv := range m {
+		k = s.normalizeKey(k)
+		for _, v := range vv {
+			s.Tags.Add(k, v)
+		}
+	}
+}
+
+// AddTagsSingle is like AddTags for tags with a single value.
+func (s *SearchEntryMutable) AddTagsSingle(m map[string]string) {
+	if s.Tags == nil {
+		s.Tags = make(SearchDataMapLarge, len(m))
+	}
+	for k, v := range m {
+		s.Tags.Add(s.normalizeKey(k), v)
+	}
+}
+
+// RemoveTag removes the tag name and all of its values from the search data. No effect if the tag is not present.
+func (s *SearchEntryMutable) RemoveTag(k string) {
+	if s.Tags == nil {
+		return
+	}
+	k = s.normalizeKey(k)
+	s.Tags.Remove(k)
+	delete(s.valueTypes, k)
+}
+
+// RemoveTagValue removes a single value of the tag from the search data, and the tag itself once it has no
+// values left. No effect if the pair is not present.
+func (s *SearchEntryMutable) RemoveTagValue(k string, v string) {
+	if s.Tags == nil {
+		return
+	}
+	k = s.normalizeKey(k)
+	s.Tags.RemoveValue(k, v)
+	s.setValueType(k, v, ValueTypeString)
+}
+
+func (s *SearchEntryMutable) normalizeKey(k string) string {
+	if s.KeyNormalizer == nil {
+		return k
+	}
+	return s.KeyNormalizer(k)
+}
+
+// Clone returns a deep copy of the search data which can be modified independently of the original.
+func (s *SearchEntryMutable) Clone() *SearchEntryMutable {
+	c := &SearchEntryMutable{
+		TraceID:           append(common.ID(nil), s.TraceID...),
+		StartTimeUnixNano: s.StartTimeUnixNano,
+		EndTimeUnixNano:   s.EndTimeUnixNano,
+		KeyNormalizer:     s.KeyNormalizer,
+	}
+
+	if s.Tags != nil {
+		c.Tags = s.Tags.Clone()
+	} else {
+		c.Tags = NewSearchDataMap()
+	}
+
+	for k, types := range s.valueTypes {
+		for v, t := range types {
+			c.setValueType(k, v, t)
+		}
+	}
+
+	return c
+}
+
+// Reset clears the entry so it can be reused. The trace ID and tags storage, and the KeyNormalizer
+// are retained.
+func (s *SearchEntryMutable) Reset() {
+	s.TraceID = s.TraceID[:0]
+	s.StartTimeUnixNano = 0
+	s.EndTimeUnixNano = 0
+	if s.Tags != nil {
+		clearSearchDataMap(s.Tags)
+	}
+	for k := range s.valueTypes {
+		delete(s.valueTypes, k)
+	}
+}
+
+// Merge adds all tags and timestamps of the other search data into this one. Both must have the same trace ID.
+func (s *SearchEntryMutable) Merge(other *SearchEntryMutable) error {
+	if !bytes.Equal(s.TraceID, other.TraceID) {
+		return ErrMergeTraceIDMismatch
+	}
+
+	if other.Tags != nil {
+		if s.Tags == nil {
+			s.Tags = NewSearchDataMap()
+		}
+		s.Tags.Merge(other.Tags)
+	}
+
+	for k, types := range other.valueTypes {
+		for v, t := range types {
+			if t > s.valueType(k, v) {
+				s.setValueType(k, v, t)
+			}
+		}
+	}
+
+	s.SetStartTimeUnixNano(other.StartTimeUnixNano)
+	s.SetEndTimeUnixNano(other.EndTimeUnixNano)
+	return nil
+}
+
+// SplitEntry partitions the tags of the entry into entries of at most maxTagsPerEntry key/value
+// pairs each, in sorted key and value order. All entries have a copy of the trace ID and the time
+// bounds of the original, so a trace found by any of them is the same trace. This trades a higher entry count for
+// a bounded size per entry, queries should dedupe results by trace ID. Returns the entry itself if
+// it is within the limit or maxTagsPerEntry is zero or less.
+func SplitEntry(e *SearchEntryMutable, maxTagsPerEntry int) []*SearchEntryMutable {
+	if e.Tags == nil || maxTagsPerEntry <= 0 || e.Tags.ValueCount() <= maxTagsPerEntry {
+		return []*SearchEntryMutable{e}
+	}
+
+	var entries []*SearchEntryMutable
+	var current *SearchEntryMutable
+	count := 0
+	RangeSorted(e.Tags, func(k, v string) {
+		if current == nil || count >= maxTagsPerEntry {
+			current = &SearchEntryMutable{
+				TraceID:           append(common.ID(nil), e.TraceID...),
+				Tags:              NewSearchDataMap(),
+				StartTimeUnixNano: e.StartTimeUnixNano,
+				EndTimeUnixNano:   e.EndTimeUnixNano,
+				KeyNormalizer:     e.KeyNormalizer,
+			}
+			entries = append(entries, current)
+			count = 0
+		}
+		current.Tags.Add(k, v)
+		current.setValueType(k, v, e.valueType(k, v))
+		count++
+	})
+
+	return entries
 }
 
 // SetStartTimeUnixNano records the earliest of all timestamps passed to this function.
@@ -37,6 +225,22 @@ func (s *SearchEntryMutable) SetEndTimeUnixNano(t uint64) {
 	}
 }
 
+// traceIDLength is the length in bytes of a valid trace ID.
+const traceIDLength = 16
+
+// Validate returns an error if the trace ID is not 16 bytes or the start time is after the end time.
+func (s *SearchEntryMutable) Validate() error {
+	if len(s.TraceID) != traceIDLength {
+		return fmt.Errorf("invalid trace id length: expected %d bytes, got %d", traceIDLength, len(s.TraceID))
+	}
+
+	if s.StartTimeUnixNano != 0 && s.EndTimeUnixNano != 0 && s.StartTimeUnixNano > s.EndTimeUnixNano {
+		return fmt.Errorf("invalid time range: start %d is after end %d", s.StartTimeUnixNano, s.EndTimeUnixNano)
+	}
+
+	return nil
+}
+
 func (s *SearchEntryMutable) ToBytes() []byte {
 	b := flatbuffers.NewBuilder(2048)
 	offset := s.WriteToBuilder(b)
@@ -44,6 +248,69 @@ func (s *SearchEntryMutable) ToBytes() []byte {
 	return b.FinishedBytes()
 }
 
+// ToBytesLimited is like ToBytes but returns an error if the serialized entry is larger than maxBytes.
+func (s *SearchEntryMutable) ToBytesLimited(maxBytes int) ([]byte, error) {
+	b := s.ToBytes()
+	if len(b) > maxBytes {
+		return nil, fmt.Errorf("search entry too large: %d bytes exceeds limit of %d bytes", len(b), maxBytes)
+	}
+	return b, nil
+}
+
+var builderPool = sync.Pool{
+	New: func() interface{} {
+		return flatbuffers.NewBuilder(2048)
+	},
+}
+
+var keyValuesPool = sync.Pool{
+	New: func() interface{} {
+		return &KeyValues{}
+	},
+}
+
+// GetKeyValues returns a KeyValues buffer from a pool, for the functions which take one to reduce
+// allocations. It references the data of the last lookup, so it must not be used beyond the page or
+// entry bytes, and must be returned with PutKeyValues once done.
+func GetKeyValues() *KeyValues {
+	return keyValuesPool.Get().(*KeyValues)
+}
+
+// PutKeyValues resets the buffer and returns it to the pool. It must not be used afterwards.
+func PutKeyValues(kv *KeyValues) {
+	// Reset so the pool doesn't keep the page bytes alive
+	*kv = KeyValues{}
+	keyValuesPool.Put(kv)
+}
+
+// ToBytesPooled is like ToBytes but uses a pooled builder. The returned bytes are a copy
+// and remain valid after the builder is recycled.
+func (s *SearchEntryMutable) ToBytesPooled() []byte {
+	b := builderPool.Get().(*flatbuffers.Builder)
+	defer func() {
+		b.Reset()
+		builderPool.Put(b)
+	}()
+
+	offset := s.WriteToBuilder(b)
+	b.Finish(offset)
+	return append([]byte(nil), b.FinishedBytes()...)
+}
+
+// Fingerprint is like SearchEntry.Fingerprint. It is computed over the encoded form of the entry,
+// so the mutable and decoded forms of the same data always agree.
+func (s *SearchEntryMutable) Fingerprint() uint64 {
+	b := builderPool.Get().(*flatbuffers.Builder)
+	defer func() {
+		b.Reset()
+		builderPool.Put(b)
+	}()
+
+	offset := s.WriteToBuilder(b)
+	b.Finish(offset)
+	return NewSearchEntryFromBytes(b.FinishedBytes()).Fingerprint()
+}
+
 func (s *SearchEntryMutable) WriteToBuilder(b *flatbuffers.Builder) flatbuffers.UOffsetT {
 	if s.Tags == nil {
 		s.Tags = NewSearchDataMap()
@@ -51,42 +318,400 @@ func (s *SearchEntryMutable) WriteToBuilder(b *flatbuffers.Builder) flatbuffers.
 
 	idOffset := b.CreateByteString(s.TraceID)
 
-	tagOffset := s.Tags.WriteToBuilder(b)
+	var tagOffset flatbuffers.UOffsetT
+	if len(s.valueTypes) > 0 {
+		tagOffset = s.writeTypedTags(b)
+	} else {
+		tagOffset = s.Tags.WriteToBuilder(b)
+	}
+	originalOffset := writeOriginalTags(b, s.Tags)
 
 	SearchEntryStart(b)
 	SearchEntryAddId(b, idOffset)
 	SearchEntryAddStartTimeUnixNano(b, s.StartTimeUnixNano)
 	SearchEntryAddEndTimeUnixNano(b, s.EndTimeUnixNano)
 	SearchEntryAddTags(b, tagOffset)
+	if originalOffset != 0 {
+		SearchEntryAddOriginalTags(b, originalOffset)
+	}
 	return SearchEntryEnd(b)
 }
 
+// SearchPageBuilderOpts controls optional limits of the SearchPageBuilder. The zero value means no limits.
+type SearchPageBuilderOpts struct {
+	// MaxTagsPerEntry is the maximum number of tag key/value pairs written for each entry.
+	// Extra pairs are dropped in sorted key and value order. Zero is unlimited.
+	MaxTagsPerEntry int
+
+	// MaxDistinctTagsPerPage is the maximum number of distinct tag key/value pairs recorded
+	// in the page-level tags. Once exceeded, pairs are still written to their entries but the
+	// page is finished without page-level tags, because incomplete tags would wrongly exclude
+	// the page. Readers then scan the entries, as for SkipBatchTags. Zero is unlimited.
+	MaxDistinctTagsPerPage int
+
+	// MaxBytes is the page size at which Full starts reporting true. Zero is unlimited.
+	MaxBytes int
+
+	// SortEntriesByStartTime writes entries in ascending order of start time instead of the
+	// order they were added, which allows FindFirstEntryAfter to binary search the page.
+	SortEntriesByStartTime bool
+
+	// RejectDuplicateTraceIDs doesn't add entries for trace IDs which are already in the page.
+	// AddDataChecked returns ErrDuplicateTraceID for them, AddData and AddDataBatch skip them.
+	RejectDuplicateTraceIDs bool
+
+	// SkipBatchTags doesn't write the page-level tags, for consumers which maintain their own
+	// index. This saves bytes, but PageContains can't exclude the page anymore and always
+	// returns true.
+	SkipBatchTags bool
+
+	// RejectInvalidTraceIDLength doesn't add entries whose trace ID is not 16 bytes.
+	// AddDataChecked returns ErrInvalidTraceIDLength for them, AddData and AddDataBatch skip them.
+	// This only validates the IDs, they are written as byte strings as before. Duplicate trace IDs
+	// are then tracked by value, which doesn't allocate per entry.
+	RejectInvalidTraceIDLength bool
+
+	// SkipEmptyValues drops tag pairs with an empty value from entries added with AddData, so they
+	// reach neither the entry nor the page-level tags. An empty value records the existence of a key,
+	// so a key which only has empty values is dropped entirely and no longer matched by Contains
+	// with an empty value. Keys with other values are unaffected.
+	SkipEmptyValues bool
+
+	// ExpectedEntries, ExpectedTags and ExpectedBytes preallocate the builder for the expected
+	// number of entries, distinct page-level tag keys, and page size in bytes. They are only hints,
+	// zero uses the defaults.
+	ExpectedEntries int
+	ExpectedTags    int
+	ExpectedBytes   int
+}
+
 type SearchPageBuilder struct {
+	stats SearchPageBuilderStats // first for 64-bit alignment of atomic fields
+
 	builder     *flatbuffers.Builder
 	allTags     SearchDataMap
 	pageEntries []flatbuffers.UOffsetT
+	entryStarts []uint64 // start time of each entry in pageEntries when sorting
+
+	opts        SearchPageBuilderOpts
+	traceIDs    map[string]struct{}              // trace IDs in the page when rejecting duplicates
+	fixedIDs    map[[traceIDLength]byte]struct{} // as traceIDs, with RejectInvalidTraceIDLength
+	finishedLen int
+	tagsDropped bool // a pair was dropped due to MaxDistinctTagsPerPage, so no page-level tags are written
+
+	pageTagsCount int // distinct pairs in allTags
+	pageTagsSize  int // estimated size of allTags when written, see estimatedKeySize
+}
+
+// SearchPageBuilderStats are counters of a SearchPageBuilder since it was created, including all
+// pages built with Reset.
+type SearchPageBuilderStats struct {
+	EntriesAdded              int64 // Entries written to pages
+	TagsAdded                 int64 // Tag key/value pairs written to entries
+	TagsDropped               int64 // Tag key/value pairs dropped due to MaxTagsPerEntry
+	PageTagsDropped           int64 // Tag key/value pairs not recorded in page-level tags due to MaxDistinctTagsPerPage
+	EmptyValuesSkipped        int64 // Tag key/value pairs with empty values dropped due to SkipEmptyValues
+	BytesWritten              int64 // Bytes written for entries
+	InvalidTraceIDsRejected   int64 // Entries not added due to RejectInvalidTraceIDLength
+	DuplicateTraceIDsRejected int64 // Entries not added due to RejectDuplicateTraceIDs
 }
 
 func NewSearchPageBuilder() *SearchPageBuilder {
+	return NewSearchPageBuilderWithOpts(SearchPageBuilderOpts{})
+}
+
+// NewSearchPageBuilderWithLimit returns a builder that reports Full once the finished page would exceed maxBytes.
+func NewSearchPageBuilderWithLimit(maxBytes int) *SearchPageBuilder {
+	return NewSearchPageBuilderWithOpts(SearchPageBuilderOpts{MaxBytes: maxBytes})
+}
+
+func NewSearchPageBuilderWithOpts(opts SearchPageBuilderOpts) *SearchPageBuilder {
+	expectedTags := opts.ExpectedTags
+	if expectedTags == 0 {
+		expectedTags = 10
+	}
+	expectedBytes := opts.ExpectedBytes
+	if expectedBytes == 0 {
+		expectedBytes = 1024
+	}
+
 	return &SearchPageBuilder{
-		builder: flatbuffers.NewBuilder(1024),
-		allTags: NewSearchDataMap(),
+		builder:     flatbuffers.NewBuilder(expectedBytes),
+		allTags:     make(SearchDataMapLarge, expectedTags),
+		pageEntries: make([]flatbuffers.UOffsetT, 0, opts.ExpectedEntries),
+		opts:        opts,
 	}
 }
 
 func (b *SearchPageBuilder) AddData(data *SearchEntryMutable) int {
-	if data.Tags != nil {
+	n, _ := b.AddDataChecked(data)
+	return n
+}
+
+// AddDataChecked is like AddData but returns ErrDuplicateTraceID instead of adding the entry if
+// RejectDuplicateTraceIDs is set and the trace is already in the page, and ErrInvalidTraceIDLength
+// if RejectInvalidTraceIDLength is set and the trace ID is not 16 bytes.
+func (b *SearchPageBuilder) AddDataChecked(data *SearchEntryMutable) (int, error) {
+	if err := b.addTraceID(data.TraceID); err != nil {
+		return 0, err
+	}
+
+	data = b.limitTags(b.skipEmptyValues(data))
+	b.addPageTags(data)
+	return b.writeEntry(data), nil
+}
+
+// addTraceID records the trace ID and returns an error if the entry is rejected.
+func (b *SearchPageBuilder) addTraceID(id common.ID) error {
+	if b.opts.RejectInvalidTraceIDLength {
+		if len(id) != traceIDLength {
+			atomic.AddInt64(&b.stats.InvalidTraceIDsRejected, 1)
+			return ErrInvalidTraceIDLength
+		}
+		if !b.opts.RejectDuplicateTraceIDs {
+			return nil
+		}
+
+		var key [traceIDLength]byte
+		copy(key[:], id)
+		if b.fixedIDs == nil {
+			b.fixedIDs = map[[traceIDLength]byte]struct{}{}
+		}
+		if _, ok := b.fixedIDs[key]; ok {
+			atomic.AddInt64(&b.stats.DuplicateTraceIDsRejected, 1)
+			return ErrDuplicateTraceID
+		}
+		b.fixedIDs[key] = struct{}{}
+		return nil
+	}
+
+	if !b.opts.RejectDuplicateTraceIDs {
+		return nil
+	}
+
+	if b.traceIDs == nil {
+		b.traceIDs = map[string]struct{}{}
+	}
+	if _, ok := b.traceIDs[string(id)]; ok {
+		atomic.AddInt64(&b.stats.DuplicateTraceIDsRejected, 1)
+		return ErrDuplicateTraceID
+	}
+	b.traceIDs[string(id)] = struct{}{}
+	return nil
+}
+
+// AddDataBatch adds all entries as if by calling AddData for each, and returns the total bytes written.
+// The page-level tags are updated for the whole batch before the entries are written.
+func (b *SearchPageBuilder) AddDataBatch(entries []*SearchEntryMutable) int {
+	if free := cap(b.pageEntries) - len(b.pageEntries); free < len(entries) {
+		pageEntries := make([]flatbuffers.UOffsetT, len(b.pageEntries), len(b.pageEntries)+len(entries))
+		copy(pageEntries, b.pageEntries)
+		b.pageEntries = pageEntries
+	}
+
+	limited := make([]*SearchEntryMutable, 0, len(entries))
+	for _, data := range entries {
+		if b.addTraceID(data.TraceID) != nil {
+			continue
+		}
+		data = b.limitTags(b.skipEmptyValues(data))
+		b.addPageTags(data)
+		limited = append(limited, data)
+	}
+
+	bytesWritten := 0
+	for _, data := range limited {
+		bytesWritten += b.writeEntry(data)
+	}
+	return bytesWritten
+}
+
+// skipEmptyValues applies SkipEmptyValues, returning a copy of the data without empty values if needed.
+func (b *SearchPageBuilder) skipEmptyValues(data *SearchEntryMutable) *SearchEntryMutable {
+	if data.Tags == nil || !b.opts.SkipEmptyValues {
+		return data
+	}
+
+	skipped := 0
+	data.Tags.Range(func(k, v string) {
+		if v == "" {
+			skipped++
+		}
+	})
+	if skipped == 0 {
+		return data
+	}
+	atomic.AddInt64(&b.stats.EmptyValuesSkipped, int64(skipped))
+
+	tags := data.Tags.Clone()
+	data.Tags.RangeKeys(func(k string) {
+		tags.RemoveValue(k, "")
+	})
+
+	// Shallow copy so the caller's data is untouched
+	skippedData := *data
+	skippedData.Tags = tags
+	return &skippedData
+}
+
+// limitTags applies MaxTagsPerEntry, returning a truncated copy of the data if needed.
+func (b *SearchPageBuilder) limitTags(data *SearchEntryMutable) *SearchEntryMutable {
+	if data.Tags == nil || b.opts.MaxTagsPerEntry <= 0 || data.Tags.ValueCount() <= b.opts.MaxTagsPerEntry {
+		return data
+	}
+
+	tags, dropped := truncateTags(data.Tags, b.opts.MaxTagsPerEntry)
+	atomic.AddInt64(&b.stats.TagsDropped, int64(dropped))
+
+	// Shallow copy so the caller's data is untouched
+	truncated := *data
+	truncated.Tags = tags
+	return &truncated
+}
+
+// addPageTags records the tags of the entry in the page-level tags, applying MaxDistinctTagsPerPage.
+func (b *SearchPageBuilder) addPageTags(data *SearchEntryMutable) {
+	if data.Tags == nil || b.opts.SkipBatchTags {
+		return
+	}
+
+	if b.opts.MaxDistinctTagsPerPage <= 0 {
 		data.Tags.Range(func(k, v string) {
-			b.allTags.Add(k, v)
+			b.addPageTag(k, v)
 		})
+		return
+	}
+
+	// Sorted so the same pairs are kept regardless of map ordering
+	RangeSorted(data.Tags, func(k, v string) {
+		if b.allTags.Contains(k, v) {
+			return
+		}
+		if b.pageTagsCount >= b.opts.MaxDistinctTagsPerPage {
+			atomic.AddInt64(&b.stats.PageTagsDropped, 1)
+			b.tagsDropped = true
+			return
+		}
+		b.addPageTag(k, v)
+	})
+}
+
+// addPageTag adds the pair to the page-level tags, and keeps their running count and estimated size
+// up to date so that Full doesn't need to walk them.
+func (b *SearchPageBuilder) addPageTag(k, v string) {
+	if b.allTags.Contains(k, v) {
+		return
+	}
+
+	keys := b.allTags.Len()
+	b.allTags.Add(k, v)
+	if b.allTags.Len() > keys {
+		b.pageTagsSize += estimatedKeySize(k)
 	}
+	b.pageTagsSize += estimatedValueSize(v)
+	b.pageTagsCount++
+}
 
+func (b *SearchPageBuilder) writeEntry(data *SearchEntryMutable) int {
 	oldOffset := b.builder.Offset()
 	offset := data.WriteToBuilder(b.builder)
 	b.pageEntries = append(b.pageEntries, offset)
+	if b.opts.SortEntriesByStartTime {
+		b.entryStarts = append(b.entryStarts, data.StartTimeUnixNano)
+	}
 
 	// bytes written
-	return int(offset - oldOffset)
+	written := int(offset - oldOffset)
+	b.recordEntry(data.Tags.ValueCount(), written)
+	return written
+}
+
+// addEncodedEntry copies an already encoded entry into the page. Its tags are not added to the
+// page-level tags.
+func (b *SearchPageBuilder) addEncodedEntry(e *SearchEntry) {
+	oldOffset := b.builder.Offset()
+	offset := copySearchEntry(b.builder, e)
+	b.pageEntries = append(b.pageEntries, offset)
+	if b.opts.SortEntriesByStartTime {
+		b.entryStarts = append(b.entryStarts, e.StartTimeUnixNano())
+	}
+
+	tags := 0
+	kv := &KeyValues{}
+	for i, l := 0, e.TagsLength(); i < l; i++ {
+		e.Tags(kv, i)
+		tags += kv.ValueLength()
+	}
+	b.recordEntry(tags, int(offset-oldOffset))
+}
+
+func (b *SearchPageBuilder) recordEntry(tags, bytesWritten int) {
+	atomic.AddInt64(&b.stats.EntriesAdded, 1)
+	atomic.AddInt64(&b.stats.TagsAdded, int64(tags))
+	atomic.AddInt64(&b.stats.BytesWritten, int64(bytesWritten))
+}
+
+// copySearchEntry writes the entry to the builder byte-for-byte, without decoding its tags into strings.
+func copySearchEntry(b *flatbuffers.Builder, e *SearchEntry) flatbuffers.UOffsetT {
+	idOffset := b.CreateByteString(e.Id())
+	tagVector := copyTags(b, e)
+
+	var originalVector flatbuffers.UOffsetT
+	if e.OriginalTagsLength() > 0 {
+		originalVector = copyTags(b, originalTags{e})
+	}
+
+	SearchEntryStart(b)
+	SearchEntryAddId(b, idOffset)
+	SearchEntryAddStartTimeUnixNano(b, e.StartTimeUnixNano())
+	SearchEntryAddEndTimeUnixNano(b, e.EndTimeUnixNano())
+	SearchEntryAddTags(b, tagVector)
+	if originalVector != 0 {
+		SearchEntryAddOriginalTags(b, originalVector)
+	}
+	return SearchEntryEnd(b)
+}
+
+// copyTags copies the tags vector of s as-is, and returns the offset of the copy.
+func copyTags(b *flatbuffers.Builder, s FBTagContainer) flatbuffers.UOffsetT {
+	kv := &KeyValues{}
+	tagOffsets := make([]flatbuffers.UOffsetT, s.TagsLength())
+	var valueOffsets []flatbuffers.UOffsetT
+	for i := range tagOffsets {
+		s.Tags(kv, i)
+		ko := b.CreateByteString(kv.Key())
+
+		valueOffsets = valueOffsets[:0]
+		for j, l := 0, kv.ValueLength(); j < l; j++ {
+			valueOffsets = append(valueOffsets, b.CreateByteString(kv.Value(j)))
+		}
+
+		var typeVector flatbuffers.UOffsetT
+		if types := kv.ValueTypeBytes(); types != nil {
+			typeVector = b.CreateByteVector(types)
+		}
+
+		// Prepend in reverse to keep the order of the source vectors
+		KeyValuesStartValueVector(b, len(valueOffsets))
+		for j := len(valueOffsets) - 1; j >= 0; j-- {
+			b.PrependUOffsetT(valueOffsets[j])
+		}
+		valueVector := b.EndVector(len(valueOffsets))
+
+		KeyValuesStart(b)
+		KeyValuesAddKey(b, ko)
+		KeyValuesAddValue(b, valueVector)
+		if typeVector != 0 {
+			KeyValuesAddValueType(b, typeVector)
+		}
+		tagOffsets[i] = KeyValuesEnd(b)
+	}
+
+	SearchEntryStartTagsVector(b, len(tagOffsets))
+	for i := len(tagOffsets) - 1; i >= 0; i-- {
+		b.PrependUOffsetT(tagOffsets[i])
+	}
+	return b.EndVector(len(tagOffsets))
 }
 
 func (b *SearchPageBuilder) Finish() []byte {
@@ -94,6 +719,10 @@ func (b *SearchPageBuilder) Finish() []byte {
 	// to the fb builder. Now we need to wrap them up in the final
 	// batch object.
 
+	if b.opts.SortEntriesByStartTime {
+		sort.Stable(entriesByStartTime{b})
+	}
+
 	// Create vector
 	SearchPageStartEntriesVector(b.builder, len(b.pageEntries))
 	for _, entry := range b.pageEntries {
@@ -101,43 +730,262 @@ func (b *SearchPageBuilder) Finish() []byte {
 	}
 	entryVector := b.builder.EndVector(len(b.pageEntries))
 
-	// Create batch-level tags
-	tagOffset := b.allTags.WriteToBuilder(b.builder)
+	// Create batch-level tags, unless they are incomplete
+	writeTags := !b.opts.SkipBatchTags && !b.tagsDropped
+	var tagOffset flatbuffers.UOffsetT
+	if writeTags {
+		tagOffset = b.allTags.WriteToBuilder(b.builder)
+	}
 
 	// Write final batch object
 	SearchPageStart(b.builder)
 	SearchPageAddEntries(b.builder, entryVector)
-	SearchPageAddTags(b.builder, tagOffset)
+	if writeTags {
+		SearchPageAddTags(b.builder, tagOffset)
+	}
 	batch := SearchPageEnd(b.builder)
 	b.builder.Finish(batch)
 	buf := b.builder.FinishedBytes()
+	b.finishedLen = len(buf)
 
 	return buf
 }
 
+// entriesByStartTime sorts the entries of a builder by start time.
+type entriesByStartTime struct {
+	b *SearchPageBuilder
+}
+
+func (s entriesByStartTime) Len() int           { return len(s.b.pageEntries) }
+func (s entriesByStartTime) Less(i, j int) bool { return s.b.entryStarts[i] < s.b.entryStarts[j] }
+func (s entriesByStartTime) Swap(i, j int) {
+	s.b.pageEntries[i], s.b.pageEntries[j] = s.b.pageEntries[j], s.b.pageEntries[i]
+	s.b.entryStarts[i], s.b.entryStarts[j] = s.b.entryStarts[j], s.b.entryStarts[i]
+}
+
+// CurrentSize returns the number of bytes written to the builder so far.
+func (b *SearchPageBuilder) CurrentSize() int {
+	return int(b.builder.Offset())
+}
+
+// Full returns true if finishing the page now would exceed the configured MaxBytes. The size of the
+// entries vector and page-level tags that are written by Finish is estimated. Always false when
+// no limit is configured.
+func (b *SearchPageBuilder) Full() bool {
+	if b.opts.MaxBytes <= 0 {
+		return false
+	}
+
+	return b.EstimatedTotalSize() > b.opts.MaxBytes
+}
+
+// EstimatedTotalSize returns the estimated size of the page if it were finished now. This includes
+// the entries written so far, and what Finish writes: the entries vector and the page-level tags.
+// The estimate is an upper bound. Because strings are shared with the entries that were already
+// written, the actual page is usually smaller by up to the raw size of the page-level tags.
+func (b *SearchPageBuilder) EstimatedTotalSize() int {
+	// Entries vector is a length followed by one offset per entry.
+	entriesSize := 4 + 4*len(b.pageEntries)
+
+	tagsSize := 0
+	if !b.opts.SkipBatchTags {
+		tagsSize = tagsVectorOverhead + b.pageTagsSize
+	}
+
+	return b.CurrentSize() + entriesSize + tagsSize + searchPageOverhead
+}
+
+// EntryCount returns the number of entries added to the current page.
+func (b *SearchPageBuilder) EntryCount() int {
+	return len(b.pageEntries)
+}
+
+// Len returns the size of the page returned by the last call to Finish, or zero if
+// the page has not been finished since the last Reset.
+func (b *SearchPageBuilder) Len() int {
+	return b.finishedLen
+}
+
+// DroppedTags returns the number of tag key/value pairs dropped due to MaxTagsPerEntry since
+// the builder was created.
+func (b *SearchPageBuilder) DroppedTags() int {
+	return int(atomic.LoadInt64(&b.stats.TagsDropped))
+}
+
+// Stats returns a snapshot of the counters. It is safe to call concurrently with the builder,
+// e.g. to export metrics.
+func (b *SearchPageBuilder) Stats() SearchPageBuilderStats {
+	return SearchPageBuilderStats{
+		EntriesAdded:              atomic.LoadInt64(&b.stats.EntriesAdded),
+		TagsAdded:                 atomic.LoadInt64(&b.stats.TagsAdded),
+		TagsDropped:               atomic.LoadInt64(&b.stats.TagsDropped),
+		PageTagsDropped:           atomic.LoadInt64(&b.stats.PageTagsDropped),
+		EmptyValuesSkipped:        atomic.LoadInt64(&b.stats.EmptyValuesSkipped),
+		BytesWritten:              atomic.LoadInt64(&b.stats.BytesWritten),
+		InvalidTraceIDsRejected:   atomic.LoadInt64(&b.stats.InvalidTraceIDsRejected),
+		DuplicateTraceIDsRejected: atomic.LoadInt64(&b.stats.DuplicateTraceIDsRejected),
+	}
+}
+
+// Reset clears the builder so it can build another page. All buffers, including the page-level
+// tags, are kept and reused.
 func (b *SearchPageBuilder) Reset() {
 	b.builder.Reset()
 	b.pageEntries = b.pageEntries[:0]
-	b.allTags = NewSearchDataMap()
+	b.entryStarts = b.entryStarts[:0]
+	b.finishedLen = 0
+	b.tagsDropped = false
+	b.pageTagsCount = 0
+	b.pageTagsSize = 0
+	clearSearchDataMap(b.allTags)
+	for id := range b.traceIDs {
+		delete(b.traceIDs, id)
+	}
+	for id := range b.fixedIDs {
+		delete(b.fixedIDs, id)
+	}
+}
+
+// AppendTraceID appends the trace ID to dst and returns the extended slice, like append. Unlike Id,
+// the result doesn't reference the entry's buffer and stays valid after the buffer is reused or
+// released. Appending many IDs to one slice copies them into a shared backing array.
+func (s *SearchEntry) AppendTraceID(dst []byte) []byte {
+	return append(dst, s.Id()...)
+}
+
+// TraceID16 returns the trace ID as a fixed-size array, and false if it is not 16 bytes.
+func (s *SearchEntry) TraceID16() (id [traceIDLength]byte, ok bool) {
+	b := s.Id()
+	if len(b) != traceIDLength {
+		return id, false
+	}
+	copy(id[:], b)
+	return id, true
 }
 
 // Get searches the entry and returns the first value found for the given key.
+// Use GetOK to tell a missing key from an empty value.
 func (s *SearchEntry) Get(k string) string {
-	kv := &KeyValues{}
-	kb := bytes.ToLower([]byte(k))
+	v, _ := s.GetOK(k)
+	return v
+}
 
-	// TODO - Use binary search since keys/values are sorted
-	for i := 0; i < s.TagsLength(); i++ {
-		s.Tags(kv, i)
-		if bytes.Equal(kv.Key(), kb) {
-			return string(kv.Value(0))
-		}
+// GetOK is like Get but also returns whether the key is present. The value is empty for an
+// existence tag.
+func (s *SearchEntry) GetOK(k string) (string, bool) {
+	buffer := GetKeyValues()
+	defer PutKeyValues(buffer)
+
+	kv := FindTag(s, buffer, bytes.ToLower([]byte(k)))
+	if kv != nil {
+		return string(kv.Value(0)), true
+	}
+
+	return "", false
+}
+
+// GetCaseSensitive is like Get but looks up the key as-is, in the tags of the entry as they were added
+// before lowercasing, and returns the value in its original case. Keys have still been through the
+// KeyNormalizer of the writer. Entries written without original tags, because all their tags were
+// lowercase or they were written by an older version, are looked up in their regular tags.
+func (s *SearchEntry) GetCaseSensitive(k string) string {
+	buffer := GetKeyValues()
+	defer PutKeyValues(buffer)
+
+	kv := FindTag(s.caseSensitiveTags(), buffer, []byte(k))
+	if kv != nil {
+		return string(kv.Value(0))
 	}
 
 	return ""
 }
 
-// Contains returns true if the key and value are found in the search data.
+// ContainsCaseSensitive is like Contains but compares the key and value as-is to the tags as they were
+// added, see GetCaseSensitive.
+func (s *SearchEntry) ContainsCaseSensitive(k []byte, v []byte, buffer *KeyValues) bool {
+	return ContainsTag(s.caseSensitiveTags(), buffer, k, v)
+}
+
+// caseSensitiveTags returns the original tags of the entry if it has them, else its regular tags.
+func (s *SearchEntry) caseSensitiveTags() FBTagContainer {
+	if s.OriginalTagsLength() > 0 {
+		return originalTags{s}
+	}
+	return s
+}
+
+// originalTags is the FBTagContainer of the tags of an entry as they were added, see GetCaseSensitive.
+type originalTags struct {
+	e *SearchEntry
+}
+
+func (o originalTags) Tags(obj *KeyValues, j int) bool {
+	return o.e.OriginalTags(obj, j)
+}
+
+func (o originalTags) TagsLength() int {
+	return o.e.OriginalTagsLength()
+}
+
+// GetAll searches the entry and returns all values found for the given key in sorted order, or nil if
+// the key is not present.
+func (s *SearchEntry) GetAll(k string) []string {
+	buffer := GetKeyValues()
+	defer PutKeyValues(buffer)
+
+	kv := FindTag(s, buffer, bytes.ToLower([]byte(k)))
+	if kv == nil {
+		return nil
+	}
+
+	values := make([]string, 0, kv.ValueLength())
+	rangeValues(kv, func(_ int, v []byte) bool {
+		values = append(values, string(v))
+		return true
+	})
+
+	return values
+}
+
+// ForeachKey invokes the callback for every tag key in the entry, in sorted order.
+// The key slice references the underlying buffer and is only valid while it is.
+func (s *SearchEntry) ForeachKey(fn func(key []byte)) {
+	kv := &KeyValues{}
+	for i, l := 0, s.TagsLength(); i < l; i++ {
+		tagAt(s, kv, i)
+		fn(kv.Key())
+	}
+}
+
+// Keys returns a copy of all tag keys in the entry, in sorted order.
+func (s *SearchEntry) Keys() []string {
+	keys := make([]string, 0, s.TagsLength())
+	s.ForeachKey(func(key []byte) {
+		keys = append(keys, string(key))
+	})
+	return keys
+}
+
+// ForeachTagValue invokes the callback for every value of the given key, in sorted order like GetAll.
+// No effect if the key is not present. The value slice references the underlying buffer and is only
+// valid while it is.
+func (s *SearchEntry) ForeachTagValue(k string, fn func(value []byte)) {
+	buffer := GetKeyValues()
+	defer PutKeyValues(buffer)
+
+	kv := FindTag(s, buffer, bytes.ToLower([]byte(k)))
+	if kv == nil {
+		return
+	}
+
+	rangeValues(kv, func(_ int, v []byte) bool {
+		fn(v)
+		return true
+	})
+}
+
+// Contains returns true if the key is found in the search data and any of its values contains v as a substring.
+// An empty v matches every value, so it checks that the key exists. Keys are never written without values.
 // Buffer KeyValue object can be passed to reduce allocations. Key and value must be
 // already converted to byte slices which match the nature of the flatbuffer data
 // which reduces allocations even further.
@@ -145,6 +993,177 @@ func (s *SearchEntry) Contains(k []byte, v []byte, buffer *KeyValues) bool {
 	return ContainsTag(s, buffer, k, v)
 }
 
+// NotContains is the negation of Contains for queries such as {service != checkout}:
+//
+//	key absent                          -> true
+//	key present, no value contains v    -> true
+//	key present, any value contains v   -> false
+//
+// The result is the same as !Contains, but matching an absent key is part of the contract
+// so that query planning can rely on it.
+func (s *SearchEntry) NotContains(k []byte, v []byte, buffer *KeyValues) bool {
+	kv := FindTag(s, buffer, k)
+	if kv == nil {
+		return true
+	}
+
+	for j, l := 0, kv.ValueLength(); j < l; j++ {
+		if bytes.Contains(kv.Value(j), v) {
+			return false
+		}
+	}
+	return true
+}
+
+// HasTag returns true if the entry contains the given key, regardless of its values.
+func (s *SearchEntry) HasTag(k string) bool {
+	buffer := GetKeyValues()
+	defer PutKeyValues(buffer)
+
+	return s.HasTagBuffer(k, buffer)
+}
+
+// HasTagBuffer is like HasTag but uses the given KeyValues buffer to reduce allocations.
+func (s *SearchEntry) HasTagBuffer(k string, buffer *KeyValues) bool {
+	return FindTag(s, buffer, bytes.ToLower([]byte(k))) != nil
+}
+
+// ContainsNormalized is like Contains but case-insensitive: the key, v and every stored value are
+// lowercased before comparing. Data written by this package is already lowercase, so this is only
+// needed for query values of unknown case or data from older writers. It costs a lowercase copy of
+// the query and of each stored value, stored values share one buffer between comparisons.
+func (s *SearchEntry) ContainsNormalized(k []byte, v []byte, buffer *KeyValues) bool {
+	return ContainsTagNormalized(s, buffer, k, v)
+}
+
+// ContainsPrefix is like Contains but returns true only if a value starts with valuePrefix.
+func (s *SearchEntry) ContainsPrefix(k []byte, valuePrefix []byte, buffer *KeyValues) bool {
+	return ContainsTagPrefix(s, buffer, k, valuePrefix)
+}
+
+// ContainsRegex is like Contains but returns true only if a value matches the regular expression.
+func (s *SearchEntry) ContainsRegex(k []byte, re *regexp.Regexp, buffer *KeyValues) bool {
+	return ContainsTagRegex(s, buffer, k, re)
+}
+
+// ContainsGlob is like Contains but returns true only if a value matches the glob pattern, see Glob
+// for the syntax. The pattern is compiled once per call, use ContainsTagGlob to reuse it across
+// entries. False if the key is absent.
+func (s *SearchEntry) ContainsGlob(k []byte, pattern string, buffer *KeyValues) bool {
+	return ContainsTagGlob(s, buffer, k, CompileGlob(pattern))
+}
+
+// ContainsExact is like Contains but returns true only if a value is equal to v. Use it for queries
+// like env=prod which must not match env=production-backup.
+func (s *SearchEntry) ContainsExact(k []byte, v []byte, buffer *KeyValues) bool {
+	return ContainsTagExact(s, buffer, k, v)
+}
+
+// ContainsAnyValue returns true if any value of the key contains any of the given values as a substring,
+// like Contains does, e.g. for queries like service IN (a, b, c). The key is only looked up once.
+func (s *SearchEntry) ContainsAnyValue(k []byte, values [][]byte, buffer *KeyValues) bool {
+	return ContainsTagAnyValue(s, buffer, k, values, false)
+}
+
+// ContainsAnyValueExact is like ContainsAnyValue but values must be equal instead of substrings.
+func (s *SearchEntry) ContainsAnyValueExact(k []byte, values [][]byte, buffer *KeyValues) bool {
+	return ContainsTagAnyValue(s, buffer, k, values, true)
+}
+
+// ContainsNumericInRange returns true if any value of the key parses as a number within the inclusive range [min, max].
+// Values that are not numeric are skipped.
+func (s *SearchEntry) ContainsNumericInRange(k []byte, min, max float64, buffer *KeyValues) bool {
+	return ContainsTagNumericInRange(s, buffer, k, min, max)
+}
+
+// Overlaps returns true if the entry's time range intersects the inclusive window [startNano, endNano].
+// A zero start time is the epoch and a zero end time is open-ended, for both the entry and the window.
+func (s *SearchEntry) Overlaps(startNano, endNano uint64) bool {
+	return overlaps(s.StartTimeUnixNano(), s.EndTimeUnixNano(), startNano, endNano)
+}
+
+// Equal returns true if both entries have the same trace ID, start and end times, and tags. The
+// original case of the tags isn't compared, see GetCaseSensitive.
+func (s *SearchEntry) Equal(other *SearchEntry) bool {
+	if !bytes.Equal(s.Id(), other.Id()) ||
+		s.StartTimeUnixNano() != other.StartTimeUnixNano() ||
+		s.EndTimeUnixNano() != other.EndTimeUnixNano() ||
+		s.TagsLength() != other.TagsLength() {
+		return false
+	}
+
+	// Tags are sorted so they can be compared in lockstep.
+	kv1, kv2 := &KeyValues{}, &KeyValues{}
+	for i, l := 0, s.TagsLength(); i < l; i++ {
+		s.Tags(kv1, i)
+		other.Tags(kv2, i)
+		if !bytes.Equal(kv1.Key(), kv2.Key()) || kv1.ValueLength() != kv2.ValueLength() {
+			return false
+		}
+		for j, vl := 0, kv1.ValueLength(); j < vl; j++ {
+			if !bytes.Equal(kv1.Value(j), kv2.Value(j)) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// Fingerprint returns a hash of the trace ID and tags of the entry. Tags are hashed in sorted order,
+// so it doesn't depend on the order they were added, and entries that are Equal have the same
+// fingerprint. Start and end times are not included.
+func (s *SearchEntry) Fingerprint() uint64 {
+	h := xxhash.New()
+	var lenBuf [4]byte
+	write := func(b []byte) {
+		// Length prefixed so that pairs can't run into each other
+		binary.LittleEndian.PutUint32(lenBuf[:], uint32(len(b)))
+		_, _ = h.Write(lenBuf[:])
+		_, _ = h.Write(b)
+	}
+
+	write(s.Id())
+
+	kv := &KeyValues{}
+	for i, l := 0, s.TagsLength(); i < l; i++ {
+		s.Tags(kv, i)
+		write(kv.Key())
+		binary.LittleEndian.PutUint32(lenBuf[:], uint32(kv.ValueLength()))
+		_, _ = h.Write(lenBuf[:])
+		for j, vl := 0, kv.ValueLength(); j < vl; j++ {
+			write(kv.Value(j))
+		}
+	}
+	return h.Sum64()
+}
+
+// TagPair is a key and value to match against search data. Like Contains, both must already
+// match the nature of the flatbuffer data.
+type TagPair struct {
+	Key   []byte
+	Value []byte
+}
+
+// ContainsAll returns true if all of the pairs are found in the search data. True when there are no pairs.
+func (s *SearchEntry) ContainsAll(pairs []TagPair, buffer *KeyValues) bool {
+	for _, p := range pairs {
+		if !ContainsTag(s, buffer, p.Key, p.Value) {
+			return false
+		}
+	}
+	return true
+}
+
+// ContainsAny returns true if at least one of the pairs is found in the search data. False when there are no pairs.
+func (s *SearchEntry) ContainsAny(pairs []TagPair, buffer *KeyValues) bool {
+	for _, p := range pairs {
+		if ContainsTag(s, buffer, p.Key, p.Value) {
+			return true
+		}
+	}
+	return false
+}
+
 func (s *SearchEntry) Reset(b []byte) {
 	n := flatbuffers.GetUOffsetT(b)
 	s.Init(b, n)
@@ -154,11 +1173,196 @@ func NewSearchEntryFromBytes(b []byte) *SearchEntry {
 	return GetRootAsSearchEntry(b, 0)
 }
 
+// NewSearchEntryFromBytesSafe is like NewSearchEntryFromBytes but returns an error instead of panicking
+// on malformed data. The whole entry is read once to check that all offsets are within bounds.
+func NewSearchEntryFromBytesSafe(b []byte) (_ *SearchEntry, err error) {
+	defer recoverMalformed(&err, "decoding search entry")
+
+	if len(b) < flatbuffers.SizeUOffsetT {
+		return nil, fmt.Errorf("error decoding search entry: too short: %d bytes", len(b))
+	}
+
+	e := NewSearchEntryFromBytes(b)
+	readEntry(e, &KeyValues{})
+	return e, nil
+}
+
+// readEntry reads all fields of the entry, which panics if the data is malformed.
+func readEntry(e *SearchEntry, kv *KeyValues) {
+	e.Id()
+	e.StartTimeUnixNano()
+	e.EndTimeUnixNano()
+	readTags(e, kv)
+	readTags(originalTags{e}, kv)
+}
+
+// readTags reads all keys, values and value types, which panics if the data is malformed.
+func readTags(s FBTagContainer, kv *KeyValues) {
+	for i, l := 0, s.TagsLength(); i < l; i++ {
+		s.Tags(kv, i)
+		kv.Key()
+		for j, vl := 0, kv.ValueLength(); j < vl; j++ {
+			kv.Value(j)
+		}
+		kv.ValueTypeBytes()
+	}
+}
+
+// FromSearchEntry reconstructs the mutable form of the entry. All data is copied so the
+// result doesn't reference the entry's underlying buffer. Value types are kept.
+func FromSearchEntry(e *SearchEntry) *SearchEntryMutable {
+	s := &SearchEntryMutable{
+		TraceID:           append(common.ID(nil), e.Id()...),
+		Tags:              TagsToDataMap(e),
+		StartTimeUnixNano: e.StartTimeUnixNano(),
+		EndTimeUnixNano:   e.EndTimeUnixNano(),
+	}
+
+	var types map[string]map[string]ValueType
+	kv := &KeyValues{}
+	for i, ii := 0, e.TagsLength(); i < ii; i++ {
+		e.Tags(kv, i)
+		for j, jj := 0, kv.ValueTypeLength(); j < jj; j++ {
+			if t := ValueType(kv.ValueType(j)); t != ValueTypeString {
+				s.setValueType(string(kv.Key()), string(kv.Value(j)), t)
+			}
+		}
+	}
+
+	// Restore the original case, which the tags are written from again. Types are recorded for
+	// the lowercased pairs, so they are moved to every original pair which lowercases to them.
+	if e.OriginalTagsLength() > 0 {
+		types, s.valueTypes = s.valueTypes, nil
+		s.Tags = TagsToDataMap(originalTags{e})
+		s.Tags.Range(func(k, v string) {
+			if t, ok := types[strings.ToLower(k)][strings.ToLower(v)]; ok {
+				s.setValueType(k, v, t)
+			}
+		})
+	}
+
+	return s
+}
+
+// TagsToDataMap copies all keys and values of the entry, or the page-level tags of a page, into a
+// new map.
+func TagsToDataMap(s FBTagContainer) SearchDataMap {
+	m := make(SearchDataMapLarge, s.TagsLength())
+
+	kv := &KeyValues{}
+	for i, ii := 0, s.TagsLength(); i < ii; i++ {
+		s.Tags(kv, i)
+		key := string(kv.Key())
+		for j, jj := 0, kv.ValueLength(); j < jj; j++ {
+			m.Add(key, string(kv.Value(j)))
+		}
+	}
+
+	return m
+}
+
+// ForeachEntry invokes the callback for every entry in the page, in the order they were added
+// to the builder, until the callback returns false. The entry is a buffer reused between calls.
+func ForeachEntry(page *SearchPage, fn func(e *SearchEntry) bool) {
+	e := &SearchEntry{}
+	for i, l := 0, page.EntriesLength(); i < l; i++ {
+		entryAt(page, e, i)
+		if !fn(e) {
+			return
+		}
+	}
+}
+
+// defaultContextCheckInterval is the number of entries scanned between checks of the context by
+// ForeachEntryCtx and SearchPagesCtx when no interval is given.
+const defaultContextCheckInterval = 256
+
+// ForeachEntryCtx is like ForeachEntry but checks the context before the first entry and then every
+// checkInterval entries, and stops with the context error once it is done. Checking is cheap but not
+// free, a lower interval cancels faster. Zero checkInterval is the default of 256.
+func ForeachEntryCtx(ctx context.Context, page *SearchPage, checkInterval int, fn func(e *SearchEntry) bool) error {
+	if checkInterval <= 0 {
+		checkInterval = defaultContextCheckInterval
+	}
+
+	e := &SearchEntry{}
+	for i, l := 0, page.EntriesLength(); i < l; i++ {
+		if i%checkInterval == 0 {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+		}
+
+		entryAt(page, e, i)
+		if !fn(e) {
+			break
+		}
+	}
+	return nil
+}
+
+// ForeachEntryInTimeRange is like ForeachEntry but only invokes the callback for entries whose time range
+// overlaps [startNano, endNano]. A zero end time, of either the entry or the range, is open-ended.
+func ForeachEntryInTimeRange(page *SearchPage, startNano, endNano uint64, fn func(e *SearchEntry) bool) {
+	ForeachEntry(page, func(e *SearchEntry) bool {
+		if !e.Overlaps(startNano, endNano) {
+			return true
+		}
+		return fn(e)
+	})
+}
+
+// overlaps returns true if the inclusive ranges [s1, e1] and [s2, e2] intersect. Zero end times are open-ended.
+func overlaps(s1, e1, s2, e2 uint64) bool {
+	return (e2 == 0 || s1 <= e2) && (e1 == 0 || s2 <= e1)
+}
+
 type FBTagContainer interface {
 	Tags(obj *KeyValues, j int) bool
 	TagsLength() int
 }
 
+// Vectors are written to flatbuffers in reverse order, so tags, values and entries are stored in
+// descending order, which is the order of the generated accessors such as KeyValues.Value. The
+// functions of this package present them in ascending order instead: iteration is ascending and
+// value indexes count from the smallest value, like the values returned by GetAll. The helpers
+// below are the only place converting between the two.
+
+// tagAt positions kv at the tag with index i in ascending key order.
+func tagAt(s FBTagContainer, kv *KeyValues, i int) {
+	s.Tags(kv, s.TagsLength()-1-i)
+}
+
+// entryAt positions e at the entry with index i, in the order the entries were added to the builder.
+func entryAt(page *SearchPage, e *SearchEntry, i int) {
+	page.Entries(e, page.EntriesLength()-1-i)
+}
+
+// valueAt returns the value of kv at index j in ascending order.
+func valueAt(kv *KeyValues, j int) []byte {
+	return kv.Value(kv.ValueLength() - 1 - j)
+}
+
+// valueTypeAt returns the type of the value of kv at index j in ascending order. The types are
+// stored parallel to the values and the vector must be present.
+func valueTypeAt(kv *KeyValues, j int) byte {
+	return kv.ValueType(kv.ValueTypeLength() - 1 - j)
+}
+
+// rangeValues invokes fn for every value of kv in ascending order, with its index, until fn returns
+// false. Returns false if fn did.
+func rangeValues(kv *KeyValues, fn func(j int, v []byte) bool) bool {
+	l := kv.ValueLength()
+	for j := 0; j < l; j++ {
+		if !fn(j, kv.Value(l-1-j)) {
+			return false
+		}
+	}
+	return true
+}
+
+// ContainsTag returns true if the key is found and any of its values contains v. An empty v matches
+// any value, i.e. the key exists.
 func ContainsTag(s FBTagContainer, kv *KeyValues, k []byte, v []byte) bool {
 
 	kv = FindTag(s, kv, k)
@@ -175,6 +1379,112 @@ func ContainsTag(s FBTagContainer, kv *KeyValues, k []byte, v []byte) bool {
 	return false
 }
 
+// containsTagFunc returns true if the key is found and match returns true for any of its values.
+func containsTagFunc(s FBTagContainer, kv *KeyValues, k []byte, match func(v []byte) bool) bool {
+	kv = FindTag(s, kv, k)
+	if kv != nil {
+		// Any match will do, so the values are checked in stored order.
+		for j, l := 0, kv.ValueLength(); j < l; j++ {
+			if match(kv.Value(j)) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// ContainsTagPrefix returns true if the key is found and any of its values starts with the prefix.
+func ContainsTagPrefix(s FBTagContainer, kv *KeyValues, k []byte, prefix []byte) bool {
+	return containsTagFunc(s, kv, k, func(v []byte) bool {
+		return bytes.HasPrefix(v, prefix)
+	})
+}
+
+// ContainsTagRegex returns true if the key is found and any of its values matches the regular expression.
+func ContainsTagRegex(s FBTagContainer, kv *KeyValues, k []byte, re *regexp.Regexp) bool {
+	return containsTagFunc(s, kv, k, re.Match)
+}
+
+// ContainsTagNormalized is the case-insensitive form of ContainsTag, see SearchEntry.ContainsNormalized.
+func ContainsTagNormalized(s FBTagContainer, kv *KeyValues, k []byte, v []byte) bool {
+	v = bytes.ToLower(v)
+	var lower []byte
+	return containsTagFunc(s, kv, bytes.ToLower(k), func(value []byte) bool {
+		lower = appendLowerBytes(lower[:0], value)
+		return bytes.Contains(lower, v)
+	})
+}
+
+// ContainsTagGlob returns true if the key is found and any of its values matches the glob.
+func ContainsTagGlob(s FBTagContainer, kv *KeyValues, k []byte, g *Glob) bool {
+	return containsTagFunc(s, kv, k, g.Match)
+}
+
+// ContainsTagExact returns true if the key is found and any of its values is equal to v.
+func ContainsTagExact(s FBTagContainer, kv *KeyValues, k []byte, v []byte) bool {
+	return containsTagFunc(s, kv, k, func(value []byte) bool {
+		return bytes.Equal(value, v)
+	})
+}
+
+// ContainsTagAnyValue returns true if the key is found and any of its values matches any of the query
+// values. Values match if they are equal when exact is set, otherwise if the query value is a substring.
+func ContainsTagAnyValue(s FBTagContainer, kv *KeyValues, k []byte, values [][]byte, exact bool) bool {
+	return containsTagFunc(s, kv, k, func(value []byte) bool {
+		for _, v := range values {
+			if exact && bytes.Equal(value, v) || !exact && bytes.Contains(value, v) {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// ContainsTagNumericInRange returns true if the key is found and any of its values parses as a number
+// within the inclusive range [min, max]. Values that are not numeric are skipped.
+func ContainsTagNumericInRange(s FBTagContainer, kv *KeyValues, k []byte, min, max float64) bool {
+	return containsTagFunc(s, kv, k, func(v []byte) bool {
+		f, err := strconv.ParseFloat(string(v), 64)
+		return err == nil && f >= min && f <= max
+	})
+}
+
+// TagValueLengths returns the length in bytes of each value of the key in ascending value order, as
+// indexed by valueAt, or nil if the key is not present. The values are not copied.
+func TagValueLengths(s FBTagContainer, kv *KeyValues, k []byte) []int {
+	kv = FindTag(s, kv, k)
+	if kv == nil {
+		return nil
+	}
+
+	lengths := make([]int, 0, kv.ValueLength())
+	rangeValues(kv, func(_ int, v []byte) bool {
+		lengths = append(lengths, len(v))
+		return true
+	})
+	return lengths
+}
+
+// FindTagValueIndex is like ContainsTag but also returns the index of the first value containing v.
+// Values are indexed in ascending order like GetAll, valueAt returns the value from kv, which is the
+// buffer positioned at the key. Returns -1, false when there is no match.
+func FindTagValueIndex(s FBTagContainer, kv *KeyValues, k, v []byte) (valueIdx int, found bool) {
+	valueIdx = -1
+	kv = FindTag(s, kv, k)
+	if kv != nil {
+		rangeValues(kv, func(j int, value []byte) bool {
+			if bytes.Contains(value, v) {
+				valueIdx = j
+				return false
+			}
+			return true
+		})
+	}
+
+	return valueIdx, valueIdx >= 0
+}
+
 func FindTag(s FBTagContainer, kv *KeyValues, k []byte) *KeyValues {
 
 	idx := binarySearch(s.TagsLength(), func(i int) int {
@@ -191,7 +1501,49 @@ func FindTag(s FBTagContainer, kv *KeyValues, k []byte) *KeyValues {
 	return nil
 }
 
-// binarySearch that finds exact matching entry. Returns non-zero index when found, or -1 when not found
+// ForeachTag invokes the callback for every key/value pair in sorted key and value order, until the
+// callback returns false. The slices reference the underlying buffer and are only valid while it is.
+func ForeachTag(s FBTagContainer, kv *KeyValues, fn func(key, value []byte) bool) {
+	for i, l := 0, s.TagsLength(); i < l; i++ {
+		tagAt(s, kv, i)
+		key := kv.Key()
+		if !rangeValues(kv, func(_ int, v []byte) bool { return fn(key, v) }) {
+			return
+		}
+	}
+}
+
+// ForeachTagKeyWithPrefix invokes the callback for every key that starts with the prefix, in sorted order,
+// until the callback returns false. The buffer is positioned at the matching key when the callback is invoked.
+func ForeachTagKeyWithPrefix(s FBTagContainer, kv *KeyValues, prefix []byte, fn func(kv *KeyValues) bool) {
+	// Keys are stored in descending order, see tagAt, so all keys >= prefix come first. Find the
+	// smallest of them, which is where keys with the prefix start, and then walk backwards.
+	n := s.TagsLength()
+	idx := lowerBound(n, func(i int) int {
+		s.Tags(kv, i)
+		return bytes.Compare(prefix, kv.Key())
+	})
+	if idx < n {
+		// Include the key equal to the prefix
+		s.Tags(kv, idx)
+		if bytes.Equal(kv.Key(), prefix) {
+			idx++
+		}
+	}
+
+	for i := idx - 1; i >= 0; i-- {
+		s.Tags(kv, i)
+		if !bytes.HasPrefix(kv.Key(), prefix) {
+			return
+		}
+		if !fn(kv) {
+			return
+		}
+	}
+}
+
+// binarySearch that finds exact matching entry. Returns the index when found, or -1 when not found.
+// Only the sign of the comparator result is used.
 // Inspired by sort.Search but makes uses of tri-state comparator to eliminate the last comparison when
 // we want to find exact match, not insertion point.
 func binarySearch(n int, compare func(int) int) int {
@@ -199,13 +1551,14 @@ func binarySearch(n int, compare func(int) int) int {
 	for i < j {
 		h := int(uint(i+j) >> 1) // avoid overflow when computing h
 		// i ≤ h < j
-		switch compare(h) {
-		case 0:
+		c := compare(h)
+		switch {
+		case c == 0:
 			// Found exact match
 			return h
-		case -1:
+		case c < 0:
 			j = h
-		case 1:
+		default:
 			i = h + 1
 		}
 	}
@@ -213,3 +1566,21 @@ func binarySearch(n int, compare func(int) int) int {
 	// No match
 	return -1
 }
+
+// lowerBound returns the first index in [0, n) where compare returns >= 0, or n if there is none.
+// Like sort.Search the comparator must be monotonic, unlike binarySearch it returns an insertion
+// point for range scans instead of an exact match.
+func lowerBound(n int, compare func(int) int) int {
+	i, j := 0, n
+	for i < j {
+		h := int(uint(i+j) >> 1) // avoid overflow when computing h
+		// i ≤ h < j
+		if compare(h) < 0 {
+			i = h + 1
+		} else {
+			j = h
+		}
+	}
+
+	return i
+}
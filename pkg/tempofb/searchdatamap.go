@@ -9,8 +9,19 @@ import (
 
 type SearchDataMap interface {
 	Add(k, v string)
+	AddNormalized(k, v string)
 	Contains(k, v string) bool
+	Get(k string) ([]string, bool)
+	Len() int
+	ValueCount() int
+	SizeBytes() int
+	Remove(k string)
+	RemoveValue(k, v string)
+	Merge(other SearchDataMap)
+	Clone() SearchDataMap
+	ToMap() map[string][]string
 	WriteToBuilder(b *flatbuffers.Builder) flatbuffers.UOffsetT
+	// Range invokes the callback for every key/value pair in unspecified order. See RangeSorted.
 	Range(f func(k, v string))
 	RangeKeys(f func(k string))
 	RangeKeyValues(k string, f func(v string))
@@ -20,6 +31,7 @@ func NewSearchDataMap() SearchDataMap {
 	return make(SearchDataMapLarge, 10) // 10 for luck
 }
 
+// NewSearchDataMapWithData returns a map with all of the given data. It is the inverse of ToMap.
 func NewSearchDataMapWithData(m map[string][]string) SearchDataMap {
 	s := NewSearchDataMap()
 
@@ -31,6 +43,77 @@ func NewSearchDataMapWithData(m map[string][]string) SearchDataMap {
 	return s
 }
 
+// clearSearchDataMap removes all keys from the map in place.
+func clearSearchDataMap(s SearchDataMap) {
+	s.RangeKeys(func(k string) {
+		s.Remove(k)
+	})
+}
+
+// RangeSorted invokes the callback for every key/value pair in sorted key and value order.
+// The Range methods of the maps visit pairs in map iteration order, which is random. Use this
+// when the order matters, e.g. when applying limits. WriteToBuilder always sorts and does not
+// depend on it.
+func RangeSorted(s SearchDataMap, f func(k, v string)) {
+	keys := make([]string, 0, s.Len())
+	s.RangeKeys(func(k string) {
+		keys = append(keys, k)
+	})
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		values, _ := s.Get(k)
+		for _, v := range values {
+			f(k, v)
+		}
+	}
+}
+
+// truncateTags returns a copy of the map containing only the first max key/value pairs in sorted
+// key and value order, and the number of pairs that were dropped.
+func truncateTags(s SearchDataMap, max int) (SearchDataMap, int) {
+	truncated := NewSearchDataMap()
+	kept, dropped := 0, 0
+	RangeSorted(s, func(k, v string) {
+		if kept < max {
+			truncated.Add(k, v)
+			kept++
+		} else {
+			dropped++
+		}
+	})
+
+	return truncated, dropped
+}
+
+const (
+	// searchPageOverhead is the page table, its vtable, the root offset and alignment.
+	searchPageOverhead = 32
+
+	// Each string has a 4 byte length, null terminator, and up to 3 bytes of padding.
+	stringOverhead = 8
+
+	// Each key has a KeyValues table with vtable, a value vector length, and an offset in the tags vector.
+	keyValuesOverhead = 24 + 4 + 4
+
+	// Each value has an offset in the value vector.
+	valueOverhead = 4
+
+	// The tags vector has a length.
+	tagsVectorOverhead = 4
+)
+
+// estimatedKeySize and estimatedValueSize return upper bounds of the number of bytes WriteToBuilder
+// writes for each key and value of the map, in addition to tagsVectorOverhead. The actual size is
+// usually smaller because strings are shared.
+func estimatedKeySize(k string) int {
+	return len(k) + stringOverhead + keyValuesOverhead
+}
+
+func estimatedValueSize(v string) int {
+	return len(v) + stringOverhead + valueOverhead
+}
+
 type SearchDataMapSmall map[string][]string
 
 func (s SearchDataMapSmall) Add(k, v string) {
@@ -53,6 +136,13 @@ func (s SearchDataMapSmall) Add(k, v string) {
 	s[k] = append(vs, v)
 }
 
+// AddNormalized is like Add but lowercases the value first, so values differing only in case are
+// stored once. Values are always lowercased when written to flatbuffers, therefore this only affects
+// the in-memory map: Contains and Get must be given lowercase values to match.
+func (s SearchDataMapSmall) AddNormalized(k, v string) {
+	s.Add(k, strings.ToLower(v))
+}
+
 func (s SearchDataMapSmall) Contains(k, v string) bool {
 	e := s[k]
 	for _, vvv := range e {
@@ -64,6 +154,90 @@ func (s SearchDataMapSmall) Contains(k, v string) bool {
 	return false
 }
 
+// Get returns a sorted copy of the values for the key, and whether the key is present.
+func (s SearchDataMapSmall) Get(k string) ([]string, bool) {
+	vs, ok := s[k]
+	if !ok {
+		return nil, false
+	}
+
+	values := append([]string(nil), vs...)
+	sort.Strings(values)
+	return values, true
+}
+
+// Len returns the number of distinct keys.
+func (s SearchDataMapSmall) Len() int {
+	return len(s)
+}
+
+// ValueCount returns the total number of values across all keys.
+func (s SearchDataMapSmall) ValueCount() int {
+	n := 0
+	for _, vs := range s {
+		n += len(vs)
+	}
+	return n
+}
+
+// SizeBytes returns the sum of the lengths of all keys and values. It does not include flatbuffer overhead.
+func (s SearchDataMapSmall) SizeBytes() int {
+	n := 0
+	for k, vs := range s {
+		n += len(k)
+		for _, v := range vs {
+			n += len(v)
+		}
+	}
+	return n
+}
+
+// Merge adds all key/value pairs of the other map. This is a union, existing
+// values are kept and duplicates are ignored.
+func (s SearchDataMapSmall) Merge(other SearchDataMap) {
+	other.Range(s.Add)
+}
+
+// Clone returns a deep copy of the map. Values keep their order.
+func (s SearchDataMapSmall) Clone() SearchDataMap {
+	c := make(SearchDataMapSmall, len(s))
+	for k, vs := range s {
+		c[k] = append([]string(nil), vs...)
+	}
+	return c
+}
+
+// ToMap returns a copy of the data as a plain map with sorted values.
+func (s SearchDataMapSmall) ToMap() map[string][]string {
+	m := make(map[string][]string, len(s))
+	for k := range s {
+		m[k], _ = s.Get(k)
+	}
+	return m
+}
+
+// Remove deletes the key and all of its values. No effect if the key is not present.
+func (s SearchDataMapSmall) Remove(k string) {
+	delete(s, k)
+}
+
+// RemoveValue deletes a single value, and the key once it has no values left. No effect if the pair is not present.
+func (s SearchDataMapSmall) RemoveValue(k, v string) {
+	vs := s[k]
+	for i := range vs {
+		if vs[i] == v {
+			vs = append(vs[:i], vs[i+1:]...)
+			break
+		}
+	}
+
+	if len(vs) == 0 {
+		delete(s, k)
+		return
+	}
+	s[k] = vs
+}
+
 func (s SearchDataMapSmall) Range(f func(k, v string)) {
 	for k, vv := range s {
 		for _, v := range vv {
@@ -94,7 +268,7 @@ func (s SearchDataMapSmall) WriteToBuilder(b *flatbuffers.Builder) flatbuffers.U
 		return s[k]
 	}
 
-	return writeToBuilder(b, keys, valuesf)
+	return writeToBuilder(b, keys, valuesf, nil)
 }
 
 type SearchDataMapLarge map[string]map[string]struct{}
@@ -113,6 +287,13 @@ func (s SearchDataMapLarge) Add(k, v string) {
 	}
 }
 
+// AddNormalized is like Add but lowercases the value first, so values differing only in case are
+// stored once. Values are always lowercased when written to flatbuffers, therefore this only affects
+// the in-memory map: Contains and Get must be given lowercase values to match.
+func (s SearchDataMapLarge) AddNormalized(k, v string) {
+	s.Add(k, strings.ToLower(v))
+}
+
 func (s SearchDataMapLarge) Contains(k, v string) bool {
 	if values, ok := s[k]; ok {
 		_, ok := values[v]
@@ -121,6 +302,93 @@ func (s SearchDataMapLarge) Contains(k, v string) bool {
 	return false
 }
 
+// Get returns a sorted copy of the values for the key, and whether the key is present.
+func (s SearchDataMapLarge) Get(k string) ([]string, bool) {
+	vs, ok := s[k]
+	if !ok {
+		return nil, false
+	}
+
+	values := make([]string, 0, len(vs))
+	for v := range vs {
+		values = append(values, v)
+	}
+	sort.Strings(values)
+	return values, true
+}
+
+// Len returns the number of distinct keys.
+func (s SearchDataMapLarge) Len() int {
+	return len(s)
+}
+
+// ValueCount returns the total number of values across all keys.
+func (s SearchDataMapLarge) ValueCount() int {
+	n := 0
+	for _, vs := range s {
+		n += len(vs)
+	}
+	return n
+}
+
+// SizeBytes returns the sum of the lengths of all keys and values. It does not include flatbuffer overhead.
+func (s SearchDataMapLarge) SizeBytes() int {
+	n := 0
+	for k, vs := range s {
+		n += len(k)
+		for v := range vs {
+			n += len(v)
+		}
+	}
+	return n
+}
+
+// Merge adds all key/value pairs of the other map. This is a union, existing
+// values are kept and duplicates are ignored.
+func (s SearchDataMapLarge) Merge(other SearchDataMap) {
+	other.Range(s.Add)
+}
+
+// Clone returns a deep copy of the map.
+func (s SearchDataMapLarge) Clone() SearchDataMap {
+	c := make(SearchDataMapLarge, len(s))
+	for k, vs := range s {
+		values := make(map[string]struct{}, len(vs))
+		for v := range vs {
+			values[v] = struct{}{}
+		}
+		c[k] = values
+	}
+	return c
+}
+
+// ToMap returns a copy of the data as a plain map with sorted values.
+func (s SearchDataMapLarge) ToMap() map[string][]string {
+	m := make(map[string][]string, len(s))
+	for k := range s {
+		m[k], _ = s.Get(k)
+	}
+	return m
+}
+
+// Remove deletes the key and all of its values. No effect if the key is not present.
+func (s SearchDataMapLarge) Remove(k string) {
+	delete(s, k)
+}
+
+// RemoveValue deletes a single value, and the key once it has no values left. No effect if the pair is not present.
+func (s SearchDataMapLarge) RemoveValue(k, v string) {
+	values, ok := s[k]
+	if !ok {
+		return
+	}
+
+	delete(values, v)
+	if len(values) == 0 {
+		delete(s, k)
+	}
+}
+
 func (s SearchDataMapLarge) Range(f func(k, v string)) {
 	for k, values := range s {
 		for v := range values {
@@ -156,45 +424,139 @@ func (s SearchDataMapLarge) WriteToBuilder(b *flatbuffers.Builder) flatbuffers.U
 		return buffer
 	}
 
-	return writeToBuilder(b, keys, valuesf)
+	return writeToBuilder(b, keys, valuesf, nil)
 }
 
-func writeToBuilder(b *flatbuffers.Builder, keys []string, valuesf func(k string, buffer []string) []string) flatbuffers.UOffsetT {
+// SearchDataMapLimited is a SearchDataMapLarge which stores at most maxValuesPerKey values
+// for each key. Further values are silently dropped.
+type SearchDataMapLimited struct {
+	SearchDataMapLarge
+	maxValuesPerKey int
+	truncated       bool
+}
 
-	var values []string
+var _ SearchDataMap = (*SearchDataMapLimited)(nil)
 
-	offsets := make([]flatbuffers.UOffsetT, 0, len(keys))
-	sort.Strings(keys)
+// NewSearchDataMapWithLimit returns a map that stores at most maxValuesPerKey values for each key.
+// Zero is unlimited.
+func NewSearchDataMapWithLimit(maxValuesPerKey int) *SearchDataMapLimited {
+	return &SearchDataMapLimited{
+		SearchDataMapLarge: make(SearchDataMapLarge, 10),
+		maxValuesPerKey:    maxValuesPerKey,
+	}
+}
 
-	for _, k := range keys {
+func (s *SearchDataMapLimited) Add(k, v string) {
+	if s.maxValuesPerKey > 0 {
+		values := s.SearchDataMapLarge[k]
+		if _, ok := values[v]; !ok && len(values) >= s.maxValuesPerKey {
+			s.truncated = true
+			return
+		}
+	}
+
+	s.SearchDataMapLarge.Add(k, v)
+}
+
+func (s *SearchDataMapLimited) AddNormalized(k, v string) {
+	s.Add(k, strings.ToLower(v))
+}
+
+// Merge adds all key/value pairs of the other map. Pairs are added in sorted order so the
+// same values are kept when the limit is reached.
+func (s *SearchDataMapLimited) Merge(other SearchDataMap) {
+	RangeSorted(other, s.Add)
+}
+
+func (s *SearchDataMapLimited) Clone() SearchDataMap {
+	return &SearchDataMapLimited{
+		SearchDataMapLarge: s.SearchDataMapLarge.Clone().(SearchDataMapLarge),
+		maxValuesPerKey:    s.maxValuesPerKey,
+		truncated:          s.truncated,
+	}
+}
+
+// Truncated returns true if any value was dropped because of the limit. It remains true
+// even if values are removed afterwards.
+func (s *SearchDataMapLimited) Truncated() bool {
+	return s.truncated
+}
+
+// writeToBuilder writes the tags sorted by key and value. Keys and values are lowercased first, and
+// keys or values which only differ in case are merged. This is the normalization contract of the
+// written data: SearchEntry.Get lowercases the key it looks up, and callers of Contains and similar
+// must pass lowercase keys and values.
+//
+// If typef is not nil it returns the ValueType of the original key and value, and a key with any
+// non-string value is written with a parallel value_type vector. A value merged from several
+// values with different types gets the highest ValueType.
+func writeToBuilder(b *flatbuffers.Builder, keys []string, valuesf func(k string, buffer []string) []string, typef func(k, v string) ValueType) flatbuffers.UOffsetT {
+
+	type key struct {
+		lower, original string
+	}
+
+	lowerKeys := make([]key, len(keys))
+	for i, k := range keys {
+		lowerKeys[i] = key{strings.ToLower(k), k}
+	}
+	sort.Slice(lowerKeys, func(i, j int) bool {
+		return lowerKeys[i].lower < lowerKeys[j].lower
+	})
+
+	var buffer, values []string
+	var types []ValueType
+
+	offsets := make([]flatbuffers.UOffsetT, 0, len(keys))
 
-		values := valuesf(k, values)
+	for i := 0; i < len(lowerKeys); {
+		k := lowerKeys[i].lower
+
+		// Collect the values of all keys which lowercase to the same key
+		values = values[:0]
+		types = types[:0]
+		for ; i < len(lowerKeys) && lowerKeys[i].lower == k; i++ {
+			buffer = valuesf(lowerKeys[i].original, buffer)
+			for _, v := range buffer {
+				values = append(values, strings.ToLower(v))
+				if typef != nil {
+					types = append(types, typef(lowerKeys[i].original, v))
+				}
+			}
+		}
 
 		// Skip empty keys
 		if len(values) <= 0 {
 			continue
 		}
 
-		ko := b.CreateSharedString(strings.ToLower(k))
-
-		// Sort values
-		sort.Strings(values)
-
-		valueStrings := make([]flatbuffers.UOffsetT, len(values))
-		for i := range values {
-			valueStrings[i] = b.CreateSharedString(strings.ToLower(values[i]))
+		// Sort and dedupe values
+		typed := false
+		if typef == nil {
+			sort.Strings(values)
+		} else {
+			sort.Sort(typedValues{values, types})
+			for _, t := range types {
+				typed = typed || t != ValueTypeString
+			}
 		}
-
-		KeyValuesStartValueVector(b, len(valueStrings))
-		for _, vs := range valueStrings {
-			b.PrependUOffsetT(vs)
+		n := 1
+		for j, v := range values[1:] {
+			if v != values[n-1] {
+				values[n] = v
+				if typed {
+					types[n] = types[j+1]
+				}
+				n++
+			}
 		}
-		valueVector := b.EndVector(len(valueStrings))
+		values = values[:n]
 
-		KeyValuesStart(b)
-		KeyValuesAddKey(b, ko)
-		KeyValuesAddValue(b, valueVector)
-		offsets = append(offsets, KeyValuesEnd(b))
+		var valueTypes []ValueType
+		if typed {
+			valueTypes = types[:n]
+		}
+		offsets = append(offsets, writeKeyValues(b, k, values, valueTypes))
 	}
 
 	SearchEntryStartTagsVector(b, len(offsets))
@@ -204,3 +566,94 @@ func writeToBuilder(b *flatbuffers.Builder, keys []string, valuesf func(k string
 	keyValueVector := b.EndVector((len(offsets)))
 	return keyValueVector
 }
+
+// writeKeyValues writes the key with the sorted values, and their parallel types if not nil.
+func writeKeyValues(b *flatbuffers.Builder, k string, values []string, types []ValueType) flatbuffers.UOffsetT {
+	ko := b.CreateSharedString(k)
+
+	valueStrings := make([]flatbuffers.UOffsetT, len(values))
+	for i := range values {
+		valueStrings[i] = b.CreateSharedString(values[i])
+	}
+
+	KeyValuesStartValueVector(b, len(valueStrings))
+	for _, vs := range valueStrings {
+		b.PrependUOffsetT(vs)
+	}
+	valueVector := b.EndVector(len(valueStrings))
+
+	var typeVector flatbuffers.UOffsetT
+	if types != nil {
+		KeyValuesStartValueTypeVector(b, len(types))
+		for _, t := range types {
+			b.PrependByte(byte(t))
+		}
+		typeVector = b.EndVector(len(types))
+	}
+
+	KeyValuesStart(b)
+	KeyValuesAddKey(b, ko)
+	KeyValuesAddValue(b, valueVector)
+	if types != nil {
+		KeyValuesAddValueType(b, typeVector)
+	}
+	return KeyValuesEnd(b)
+}
+
+// writeOriginalTags writes the tags as they were added, sorted by key and value but not lowercased,
+// for SearchEntry.GetCaseSensitive. Returns 0 without writing anything if all keys and values are
+// lowercase already, because the tags written by writeToBuilder are then the same.
+func writeOriginalTags(b *flatbuffers.Builder, s SearchDataMap) flatbuffers.UOffsetT {
+	mixedCase := false
+	s.Range(func(k, v string) {
+		mixedCase = mixedCase || strings.ToLower(k) != k || strings.ToLower(v) != v
+	})
+	if !mixedCase {
+		return 0
+	}
+
+	keys := make([]string, 0, s.Len())
+	s.RangeKeys(func(k string) {
+		keys = append(keys, k)
+	})
+	sort.Strings(keys)
+
+	var values []string
+	offsets := make([]flatbuffers.UOffsetT, 0, len(keys))
+	for _, k := range keys {
+		values = values[:0]
+		s.RangeKeyValues(k, func(v string) {
+			values = append(values, v)
+		})
+		if len(values) == 0 {
+			continue
+		}
+		sort.Strings(values)
+		offsets = append(offsets, writeKeyValues(b, k, values, nil))
+	}
+
+	SearchEntryStartOriginalTagsVector(b, len(offsets))
+	for _, kvo := range offsets {
+		b.PrependUOffsetT(kvo)
+	}
+	return b.EndVector(len(offsets))
+}
+
+// typedValues sorts values and their parallel types by value, and the highest type first for equal
+// values so that deduping keeps it.
+type typedValues struct {
+	values []string
+	types  []ValueType
+}
+
+func (t typedValues) Len() int { return len(t.values) }
+func (t typedValues) Less(i, j int) bool {
+	if t.values[i] != t.values[j] {
+		return t.values[i] < t.values[j]
+	}
+	return t.types[i] > t.types[j]
+}
+func (t typedValues) Swap(i, j int) {
+	t.values[i], t.values[j] = t.values[j], t.values[i]
+	t.types[i], t.types[j] = t.types[j], t.types[i]
+}
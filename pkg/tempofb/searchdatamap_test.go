@@ -14,6 +14,7 @@ func TestSearchDataMap(t *testing.T) {
 	}{
 		{"SearchDataMapSmall", &SearchDataMapSmall{}},
 		{"SearchDataMapLarge", &SearchDataMapLarge{}},
+		{"SearchDataMapLimited", NewSearchDataMapWithLimit(0)},
 	}
 
 	for _, tc := range testCases {
@@ -32,6 +33,18 @@ func TestSearchDataMap(t *testing.T) {
 			assert.True(t, searchDataMap.Contains("key-1", "value-1-2"))
 			assert.False(t, searchDataMap.Contains("key-2", "value-1-2"))
 
+			assert.Equal(t, 2, searchDataMap.Len())
+			assert.Equal(t, 3, searchDataMap.ValueCount())
+			assert.Equal(t, 5+9+5+9+9, searchDataMap.SizeBytes())
+
+			values, ok := searchDataMap.Get("key-1")
+			assert.True(t, ok)
+			assert.Equal(t, []string{"value-1-1", "value-1-2"}, values)
+
+			values, ok = searchDataMap.Get("does-not-exist")
+			assert.False(t, ok)
+			assert.Nil(t, values)
+
 			type Pair struct {
 				k string
 				v string
@@ -68,6 +81,222 @@ func TestSearchDataMap(t *testing.T) {
 	}
 }
 
+func TestSearchDataMapRemove(t *testing.T) {
+	testCases := []struct {
+		name string
+		impl SearchDataMap
+	}{
+		{"SearchDataMapSmall", SearchDataMapSmall{}},
+		{"SearchDataMapLarge", SearchDataMapLarge{}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			s := tc.impl
+			s.Add("key-1", "value-1-1")
+			s.Add("key-1", "value-1-2")
+			s.Add("key-1", "value-1-3")
+			s.Add("key-2", "value-2-1")
+			s.Add("key-3", "value-3-1")
+
+			// Absent data
+			s.Remove("does-not-exist")
+			s.RemoveValue("does-not-exist", "value-1-1")
+			s.RemoveValue("key-1", "does-not-exist")
+			assert.Equal(t, 5, s.ValueCount())
+
+			s.RemoveValue("key-1", "value-1-2")
+			values, ok := s.Get("key-1")
+			assert.True(t, ok)
+			assert.Equal(t, []string{"value-1-1", "value-1-3"}, values)
+
+			s.RemoveValue("key-2", "value-2-1")
+			_, ok = s.Get("key-2")
+			assert.False(t, ok)
+
+			s.Remove("key-3")
+			_, ok = s.Get("key-3")
+			assert.False(t, ok)
+
+			assert.Equal(t, 1, s.Len())
+
+			// Re-adding a removed value
+			s.Add("key-1", "value-1-2")
+			values, _ = s.Get("key-1")
+			assert.Equal(t, []string{"value-1-1", "value-1-2", "value-1-3"}, values)
+		})
+	}
+}
+
+func TestSearchDataMapMerge(t *testing.T) {
+	testCases := []struct {
+		name string
+		impl SearchDataMap
+	}{
+		{"SearchDataMapSmall", SearchDataMapSmall{}},
+		{"SearchDataMapLarge", SearchDataMapLarge{}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			s := tc.impl
+			s.Add("key-1", "value-1-1")
+			s.Add("key-1", "value-1-2")
+
+			other := NewSearchDataMapWithData(map[string][]string{
+				"key-1": {"value-1-2", "value-1-3"},
+				"key-2": {"value-2-1"},
+			})
+
+			s.Merge(other)
+
+			values, _ := s.Get("key-1")
+			assert.Equal(t, []string{"value-1-1", "value-1-2", "value-1-3"}, values)
+			values, _ = s.Get("key-2")
+			assert.Equal(t, []string{"value-2-1"}, values)
+			assert.Equal(t, 4, s.ValueCount())
+
+			// Other is unchanged
+			assert.Equal(t, 3, other.ValueCount())
+		})
+	}
+}
+
+func TestSearchDataMapClone(t *testing.T) {
+	testCases := []struct {
+		name string
+		impl SearchDataMap
+	}{
+		{"SearchDataMapSmall", SearchDataMapSmall{}},
+		{"SearchDataMapLarge", SearchDataMapLarge{}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			s := tc.impl
+			s.Add("key-1", "value-1-2")
+			s.Add("key-1", "value-1-1")
+
+			c := s.Clone()
+			assert.IsType(t, s, c)
+			assert.Equal(t, s, c)
+
+			c.Add("key-1", "value-1-3")
+			c.Add("key-2", "value-2-1")
+			c.RemoveValue("key-1", "value-1-1")
+
+			values, _ := s.Get("key-1")
+			assert.Equal(t, []string{"value-1-1", "value-1-2"}, values)
+			assert.Equal(t, 1, s.Len())
+		})
+	}
+}
+
+func TestSearchDataMapToMap(t *testing.T) {
+	m := map[string][]string{
+		"key-1": {"value-1-2", "value-1-1", "value-1-2"},
+		"key-2": {"value-2-1"},
+	}
+
+	s := NewSearchDataMapWithData(m)
+	out := s.ToMap()
+	assert.Equal(t, map[string][]string{
+		"key-1": {"value-1-1", "value-1-2"},
+		"key-2": {"value-2-1"},
+	}, out)
+
+	// Not aliased
+	out["key-2"][0] = "changed"
+	values, _ := s.Get("key-2")
+	assert.Equal(t, []string{"value-2-1"}, values)
+
+	small := SearchDataMapSmall{}
+	small.Merge(s)
+	assert.Equal(t, s.ToMap(), small.ToMap())
+}
+
+func TestSearchDataMapAddNormalized(t *testing.T) {
+	testCases := []struct {
+		name string
+		impl SearchDataMap
+	}{
+		{"SearchDataMapSmall", SearchDataMapSmall{}},
+		{"SearchDataMapLarge", SearchDataMapLarge{}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			s := tc.impl
+			s.AddNormalized("http.method", "GET")
+			s.AddNormalized("http.method", "get")
+			s.AddNormalized("http.method", "Get")
+
+			values, _ := s.Get("http.method")
+			assert.Equal(t, []string{"get"}, values)
+			assert.True(t, s.Contains("http.method", "get"))
+		})
+	}
+}
+
+func TestRangeSorted(t *testing.T) {
+	testCases := []struct {
+		name string
+		impl SearchDataMap
+	}{
+		{"SearchDataMapSmall", SearchDataMapSmall{}},
+		{"SearchDataMapLarge", SearchDataMapLarge{}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			s := tc.impl
+			for i := 9; i >= 0; i-- {
+				for j := 9; j >= 0; j-- {
+					s.Add(fmt.Sprintf("key-%d", i), fmt.Sprintf("value-%d-%d", i, j))
+				}
+			}
+
+			var expected []string
+			for i := 0; i < 10; i++ {
+				for j := 0; j < 10; j++ {
+					expected = append(expected, fmt.Sprintf("key-%d=value-%d-%d", i, i, j))
+				}
+			}
+
+			for n := 0; n < 10; n++ {
+				var actual []string
+				RangeSorted(s, func(k, v string) {
+					actual = append(actual, k+"="+v)
+				})
+				assert.Equal(t, expected, actual)
+			}
+		})
+	}
+}
+
+func TestSearchDataMapLimited(t *testing.T) {
+	s := NewSearchDataMapWithLimit(2)
+	s.Add("key-1", "value-1-1")
+	s.Add("key-1", "value-1-2")
+	s.Add("key-1", "value-1-1")
+	assert.False(t, s.Truncated())
+
+	s.Add("key-1", "value-1-3")
+	s.Add("key-2", "value-2-1")
+	assert.True(t, s.Truncated())
+
+	values, _ := s.Get("key-1")
+	assert.Equal(t, []string{"value-1-1", "value-1-2"}, values)
+	values, _ = s.Get("key-2")
+	assert.Equal(t, []string{"value-2-1"}, values)
+
+	c := s.Clone().(*SearchDataMapLimited)
+	assert.True(t, c.Truncated())
+	c.Merge(NewSearchDataMapWithData(map[string][]string{"key-2": {"value-2-2", "value-2-3"}}))
+	values, _ = c.Get("key-2")
+	assert.Equal(t, []string{"value-2-1", "value-2-2"}, values)
+}
+
 func BenchmarkSearchDataMapAdd(b *testing.B) {
 	intfs := []struct {
 		name string
@@ -0,0 +1,48 @@
+package tempofb
+
+import (
+	"github.com/grafana/tempo/tempodb/encoding/common"
+)
+
+// EntryBuilder builds a SearchEntryMutable with chained calls:
+//
+//	e, err := NewEntry(traceID).Tag("service.name", "checkout").Start(start).End(end).Build()
+type EntryBuilder struct {
+	entry *SearchEntryMutable
+}
+
+func NewEntry(traceID common.ID) *EntryBuilder {
+	return &EntryBuilder{
+		entry: &SearchEntryMutable{
+			TraceID: traceID,
+			Tags:    NewSearchDataMap(),
+		},
+	}
+}
+
+// Tag adds the tag name and value, see SearchEntryMutable.AddTag.
+func (b *EntryBuilder) Tag(k, v string) *EntryBuilder {
+	b.entry.AddTag(k, v)
+	return b
+}
+
+// Start sets the start time.
+func (b *EntryBuilder) Start(t uint64) *EntryBuilder {
+	b.entry.StartTimeUnixNano = t
+	return b
+}
+
+// End sets the end time.
+func (b *EntryBuilder) End(t uint64) *EntryBuilder {
+	b.entry.EndTimeUnixNano = t
+	return b
+}
+
+// Build returns the entry, or an error if it is not valid, see SearchEntryMutable.Validate.
+// The builder must not be used afterwards.
+func (b *EntryBuilder) Build() (*SearchEntryMutable, error) {
+	if err := b.entry.Validate(); err != nil {
+		return nil, err
+	}
+	return b.entry, nil
+}
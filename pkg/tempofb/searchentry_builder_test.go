@@ -0,0 +1,31 @@
+package tempofb
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestEntryBuilder(t *testing.T) {
+	traceID := []byte("0123456789abcdef")
+
+	e, err := NewEntry(traceID).
+		Tag("service.name", "checkout").
+		Tag("http.method", "GET").
+		Tag("http.method", "GET").
+		Start(10).
+		End(20).
+		Build()
+	require.NoError(t, err)
+
+	expected := &SearchEntryMutable{TraceID: traceID, StartTimeUnixNano: 10, EndTimeUnixNano: 20}
+	expected.AddTag("service.name", "checkout")
+	expected.AddTag("http.method", "GET")
+	require.Equal(t, expected.ToBytes(), e.ToBytes())
+
+	_, err = NewEntry([]byte{1, 2, 3}).Build()
+	require.Error(t, err)
+
+	_, err = NewEntry(traceID).Start(20).End(10).Build()
+	require.Error(t, err)
+}
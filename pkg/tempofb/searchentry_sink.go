@@ -0,0 +1,26 @@
+package tempofb
+
+import "fmt"
+
+// EntrySink serializes entries one at a time and passes them to a callback, e.g. to stream them
+// to a queue, instead of accumulating a whole page in memory.
+type EntrySink struct {
+	fn func(entryBytes []byte) error
+}
+
+// NewEntrySink returns a sink which invokes fn with each serialized entry. The bytes are a copy
+// owned by fn.
+func NewEntrySink(fn func(entryBytes []byte) error) *EntrySink {
+	return &EntrySink{
+		fn: fn,
+	}
+}
+
+// Add serializes the entry with a pooled builder and invokes the callback. Errors returned by the
+// callback are wrapped and returned.
+func (s *EntrySink) Add(data *SearchEntryMutable) error {
+	if err := s.fn(data.ToBytesPooled()); err != nil {
+		return fmt.Errorf("error sinking search entry: %w", err)
+	}
+	return nil
+}
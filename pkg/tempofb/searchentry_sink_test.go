@@ -0,0 +1,35 @@
+package tempofb
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestEntrySink(t *testing.T) {
+	var received [][]byte
+	sink := NewEntrySink(func(entryBytes []byte) error {
+		received = append(received, entryBytes)
+		return nil
+	})
+
+	for i := 0; i < 3; i++ {
+		e := &SearchEntryMutable{TraceID: []byte{byte(i)}}
+		e.AddTag("foo", "bar")
+		require.NoError(t, sink.Add(e))
+	}
+
+	require.Len(t, received, 3)
+	for i, b := range received {
+		e := NewSearchEntryFromBytes(b)
+		require.Equal(t, []byte{byte(i)}, e.Id())
+		require.Equal(t, "bar", e.Get("foo"))
+	}
+
+	errSink := errors.New("sink failed")
+	sink = NewEntrySink(func([]byte) error {
+		return errSink
+	})
+	require.ErrorIs(t, sink.Add(&SearchEntryMutable{}), errSink)
+}
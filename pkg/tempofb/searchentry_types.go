@@ -0,0 +1,106 @@
+package tempofb
+
+import (
+	flatbuffers "github.com/google/flatbuffers/go"
+)
+
+// ValueType is the original type of a tag value. Values are always stored and matched as strings,
+// the type only allows consumers to interpret them, e.g. to compare numbers.
+type ValueType byte
+
+const (
+	// ValueTypeString is the zero value, and the type of all values written without a type.
+	ValueTypeString ValueType = iota
+	ValueTypeInt
+	ValueTypeFloat
+	ValueTypeBool
+)
+
+func (t ValueType) String() string {
+	switch t {
+	case ValueTypeString:
+		return "string"
+	case ValueTypeInt:
+		return "int"
+	case ValueTypeFloat:
+		return "float"
+	case ValueTypeBool:
+		return "bool"
+	}
+	return "unknown"
+}
+
+// AddTagTyped adds the tag name and value like AddTag, and records the original type of the value.
+// Types are written to the entry, but not to the page-level tags. Equal and Fingerprint ignore them.
+func (s *SearchEntryMutable) AddTagTyped(k string, v string, t ValueType) {
+	s.AddTag(k, v)
+	s.setValueType(s.normalizeKey(k), v, t)
+}
+
+// ValueType returns the type of the value at index idx of the tag. Values are indexed in ascending
+// order, so idx pairs with the values of GetAll and ForeachTagValue. Values written without a type,
+// and tags which aren't present, are ValueTypeString.
+func (s *SearchEntry) ValueType(k []byte, idx int) ValueType {
+	buffer := GetKeyValues()
+	defer PutKeyValues(buffer)
+
+	kv := FindTag(s, buffer, k)
+	if kv == nil {
+		return ValueTypeString
+	}
+	return TagValueType(kv, idx)
+}
+
+// TagValueType returns the type of the value at index j, in ascending order like valueAt, or
+// ValueTypeString if the tag was written without types.
+func TagValueType(kv *KeyValues, j int) ValueType {
+	if j < 0 || j >= kv.ValueTypeLength() {
+		return ValueTypeString
+	}
+	return ValueType(valueTypeAt(kv, j))
+}
+
+func (s *SearchEntryMutable) valueType(k, v string) ValueType {
+	return s.valueTypes[k][v]
+}
+
+// setValueType records the type of the value. Only non-string types are stored.
+func (s *SearchEntryMutable) setValueType(k, v string, t ValueType) {
+	if t == ValueTypeString {
+		if types, ok := s.valueTypes[k]; ok {
+			delete(types, v)
+			if len(types) == 0 {
+				delete(s.valueTypes, k)
+			}
+		}
+		return
+	}
+
+	if s.valueTypes == nil {
+		s.valueTypes = map[string]map[string]ValueType{}
+	}
+	types, ok := s.valueTypes[k]
+	if !ok {
+		types = map[string]ValueType{}
+		s.valueTypes[k] = types
+	}
+	types[v] = t
+}
+
+// writeTypedTags writes the tags like SearchDataMap.WriteToBuilder, including the value types.
+func (s *SearchEntryMutable) writeTypedTags(b *flatbuffers.Builder) flatbuffers.UOffsetT {
+	keys := make([]string, 0, s.Tags.Len())
+	s.Tags.RangeKeys(func(k string) {
+		keys = append(keys, k)
+	})
+
+	valuesf := func(k string, buffer []string) []string {
+		buffer = buffer[:0]
+		s.Tags.RangeKeyValues(k, func(v string) {
+			buffer = append(buffer, v)
+		})
+		return buffer
+	}
+
+	return writeToBuilder(b, keys, valuesf, s.valueType)
+}
@@ -0,0 +1,86 @@
+package tempofb
+
+import (
+	"bytes"
+	"strings"
+	"unicode/utf8"
+)
+
+// Glob is a compiled glob pattern for matching tag values. A '*' matches any sequence of characters,
+// including the empty sequence, and a '?' matches exactly one UTF-8 encoded character. All other
+// bytes match themselves, there is no escaping and there are no character classes. The pattern must
+// match the whole value, e.g. /api/* matches /api/users but not /v1/api/users. Like Contains, the
+// pattern is compared to the written data as-is and should be lowercase.
+type Glob struct {
+	pattern []byte
+	match   func(p, v []byte) bool
+}
+
+// CompileGlob compiles the pattern. Patterns without wildcards, or with a single * at either end,
+// are matched without backtracking.
+func CompileGlob(pattern string) *Glob {
+	g := &Glob{pattern: []byte(pattern)}
+
+	wildcards := strings.Count(pattern, "*") + strings.Count(pattern, "?")
+	switch {
+	case wildcards == 0:
+		g.match = bytes.Equal
+	case wildcards == 1 && strings.HasSuffix(pattern, "*"):
+		g.pattern = g.pattern[:len(g.pattern)-1]
+		g.match = func(p, v []byte) bool { return bytes.HasPrefix(v, p) }
+	case wildcards == 1 && strings.HasPrefix(pattern, "*"):
+		g.pattern = g.pattern[1:]
+		g.match = func(p, v []byte) bool { return bytes.HasSuffix(v, p) }
+	default:
+		g.match = matchGlob
+	}
+
+	return g
+}
+
+// Match returns true if the value matches the pattern.
+func (g *Glob) Match(v []byte) bool {
+	return g.match(g.pattern, v)
+}
+
+// matchGlob matches the value against the pattern, backtracking to the last * on a mismatch.
+func matchGlob(p, v []byte) bool {
+	px, vx := 0, 0
+	starPx, starVx := -1, 0
+
+	for vx < len(v) {
+		if px < len(p) {
+			switch c := p[px]; c {
+			case '*':
+				starPx, starVx = px, vx
+				px++
+				continue
+			case '?':
+				_, n := utf8.DecodeRune(v[vx:])
+				px++
+				vx += n
+				continue
+			default:
+				if v[vx] == c {
+					px++
+					vx++
+					continue
+				}
+			}
+		}
+
+		if starPx < 0 {
+			return false
+		}
+
+		// Let the last * consume one more character and retry
+		_, n := utf8.DecodeRune(v[starVx:])
+		starVx += n
+		px, vx = starPx+1, starVx
+	}
+
+	for px < len(p) && p[px] == '*' {
+		px++
+	}
+	return px == len(p)
+}
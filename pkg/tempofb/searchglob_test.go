@@ -0,0 +1,54 @@
+package tempofb
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestGlobMatch(t *testing.T) {
+	testCases := []struct {
+		pattern  string
+		value    string
+		expected bool
+	}{
+		{"", "", true},
+		{"", "a", false},
+		{"abc", "abc", true},
+		{"abc", "abcd", false},
+		{"*", "", true},
+		{"*", "anything", true},
+		{"/api/*", "/api/users", true},
+		{"/api/*", "/api/", true},
+		{"/api/*", "/v1/api/users", false},
+		{"*.json", "data.json", true},
+		{"*.json", "data.jsonl", false},
+		{"a?c", "abc", true},
+		{"a?c", "ac", false},
+		{"a?c", "aäc", true},
+		{"/api/*/users/*", "/api/v1/users/42", true},
+		{"/api/*/users/*", "/api/v1/groups/42", false},
+		{"*a*b*", "xxaxxbxx", true},
+		{"*a*b*", "xxbxxaxx", false},
+		{"a*b?", "aXbbY", true},
+		{"**", "x", true},
+		{"?*", "", false},
+	}
+
+	for _, tc := range testCases {
+		require.Equal(t, tc.expected, CompileGlob(tc.pattern).Match([]byte(tc.value)), "%q %q", tc.pattern, tc.value)
+	}
+}
+
+func TestSearchEntryContainsGlob(t *testing.T) {
+	s := &SearchEntryMutable{}
+	s.AddTag("http.url", "/api/users")
+	s.AddTag("http.url", "/health")
+	e := GetRootAsSearchEntry(s.ToBytes(), 0)
+	kv := &KeyValues{}
+
+	require.True(t, e.ContainsGlob([]byte("http.url"), "/api/*", kv))
+	require.True(t, e.ContainsGlob([]byte("http.url"), "/heal??", kv))
+	require.False(t, e.ContainsGlob([]byte("http.url"), "/v1/*", kv))
+	require.False(t, e.ContainsGlob([]byte("missing"), "*", kv))
+}
@@ -0,0 +1,108 @@
+package tempofb
+
+import (
+	"container/list"
+	"sync"
+)
+
+// PageCache is an LRU cache of decoded search pages bounded by the total size of their buffers.
+// Decoded pages reference their buffers, so both are kept together. It is safe for concurrent use.
+// Cached pages are shared between callers and must not be modified or Reset.
+type PageCache struct {
+	mtx      sync.Mutex
+	maxBytes int
+	size     int
+	lru      *list.List // of *pageCacheEntry, most recently used first
+	entries  map[string]*list.Element
+}
+
+type pageCacheEntry struct {
+	key  string
+	buf  []byte
+	page *SearchPage
+}
+
+// NewPageCache returns a cache which holds pages up to a total of maxBytes.
+func NewPageCache(maxBytes int) *PageCache {
+	return &PageCache{
+		maxBytes: maxBytes,
+		lru:      list.New(),
+		entries:  map[string]*list.Element{},
+	}
+}
+
+// Get returns the cached page of the key, or loads, decodes and caches it. The loader is called
+// without holding the lock, so concurrent misses of the same key may each call it; the first result
+// is cached. Pages larger than the whole budget are returned but not cached. Returns an error if the
+// loader fails or the loaded page is malformed, errors are not cached.
+func (c *PageCache) Get(key string, loader func() ([]byte, error)) (*SearchPage, error) {
+	c.mtx.Lock()
+	if e, ok := c.entries[key]; ok {
+		c.lru.MoveToFront(e)
+		c.mtx.Unlock()
+		return e.Value.(*pageCacheEntry).page, nil
+	}
+	c.mtx.Unlock()
+
+	buf, err := loader()
+	if err != nil {
+		return nil, err
+	}
+
+	page, err := NewSearchPageFromBytesSafe(buf)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	if e, ok := c.entries[key]; ok {
+		c.lru.MoveToFront(e)
+		return e.Value.(*pageCacheEntry).page, nil
+	}
+
+	if len(buf) > c.maxBytes {
+		return page, nil
+	}
+
+	c.entries[key] = c.lru.PushFront(&pageCacheEntry{key: key, buf: buf, page: page})
+	c.size += len(buf)
+	for c.size > c.maxBytes {
+		c.removeElement(c.lru.Back())
+	}
+
+	return page, nil
+}
+
+// Remove drops the page of the key from the cache, e.g. after the underlying data was replaced.
+func (c *PageCache) Remove(key string) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	if e, ok := c.entries[key]; ok {
+		c.removeElement(e)
+	}
+}
+
+// Len returns the number of cached pages.
+func (c *PageCache) Len() int {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	return c.lru.Len()
+}
+
+// Size returns the total bytes of the cached pages.
+func (c *PageCache) Size() int {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	return c.size
+}
+
+func (c *PageCache) removeElement(e *list.Element) {
+	entry := c.lru.Remove(e).(*pageCacheEntry)
+	delete(c.entries, entry.key)
+	c.size -= len(entry.buf)
+}
@@ -0,0 +1,95 @@
+package tempofb
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPageCache(t *testing.T) {
+	pages := map[string][]byte{}
+	for _, k := range []string{"a", "b", "c"} {
+		b := NewSearchPageBuilder()
+		b.AddData(&SearchEntryMutable{TraceID: []byte(k)})
+		pages[k] = b.Finish()
+	}
+	size := len(pages["a"])
+
+	loads := map[string]int{}
+	loader := func(k string) func() ([]byte, error) {
+		return func() ([]byte, error) {
+			loads[k]++
+			return pages[k], nil
+		}
+	}
+
+	// Room for two pages
+	c := NewPageCache(2 * size)
+
+	for _, k := range []string{"a", "b", "a"} {
+		page, err := c.Get(k, loader(k))
+		require.NoError(t, err)
+		e := &SearchEntry{}
+		page.Entries(e, 0)
+		require.Equal(t, []byte(k), e.Id())
+	}
+	require.Equal(t, map[string]int{"a": 1, "b": 1}, loads)
+	require.Equal(t, 2, c.Len())
+	require.Equal(t, 2*size, c.Size())
+
+	// b is the least recently used and evicted
+	_, err := c.Get("c", loader("c"))
+	require.NoError(t, err)
+	_, err = c.Get("a", loader("a"))
+	require.NoError(t, err)
+	_, err = c.Get("b", loader("b"))
+	require.NoError(t, err)
+	require.Equal(t, map[string]int{"a": 1, "b": 2, "c": 1}, loads)
+	require.Equal(t, 2, c.Len())
+
+	c.Remove("b")
+	require.Equal(t, 1, c.Len())
+	require.Equal(t, size, c.Size())
+
+	// Errors and malformed pages are not cached
+	loaderErr := errors.New("loader failed")
+	_, err = c.Get("err", func() ([]byte, error) { return nil, loaderErr })
+	require.Equal(t, loaderErr, err)
+	_, err = c.Get("malformed", func() ([]byte, error) { return []byte{0xFF, 0xFF, 0xFF, 0xFF}, nil })
+	require.Error(t, err)
+	require.Equal(t, 1, c.Len())
+
+	// Pages larger than the budget are returned but not cached
+	small := NewPageCache(size - 1)
+	page, err := small.Get("a", loader("a"))
+	require.NoError(t, err)
+	require.Equal(t, 1, page.EntriesLength())
+	require.Equal(t, 0, small.Len())
+}
+
+func TestPageCacheConcurrent(t *testing.T) {
+	b := NewSearchPageBuilder()
+	b.AddData(&SearchEntryMutable{TraceID: []byte{1}})
+	buf := b.Finish()
+
+	c := NewPageCache(10 * len(buf))
+	wg := sync.WaitGroup{}
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				page, err := c.Get(fmt.Sprint((i+j)%20), func() ([]byte, error) { return buf, nil })
+				require.NoError(t, err)
+				require.Equal(t, 1, page.EntriesLength())
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	require.Equal(t, 10, c.Len())
+	require.Equal(t, 10*len(buf), c.Size())
+}
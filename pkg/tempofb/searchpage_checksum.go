@@ -0,0 +1,49 @@
+package tempofb
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+
+	flatbuffers "github.com/google/flatbuffers/go"
+)
+
+// ErrChecksumMismatch is returned when a page doesn't match its checksum, i.e. it is corrupted.
+var ErrChecksumMismatch = errors.New("search page checksum mismatch")
+
+const checksumLength = 4
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// FinishWithChecksum is like Finish but appends a CRC32C of the page as a trailer. The result
+// must be read with DecodeSearchPageChecked.
+func (b *SearchPageBuilder) FinishWithChecksum() []byte {
+	page := b.Finish()
+
+	buf := make([]byte, len(page)+checksumLength)
+	copy(buf, page)
+	binary.LittleEndian.PutUint32(buf[len(page):], crc32.Checksum(page, castagnoli))
+	return buf
+}
+
+// DecodeSearchPageChecked verifies and decodes a page written by FinishWithChecksum. Returns an
+// error wrapping ErrChecksumMismatch if the page is corrupted, and an error if the checksum matches
+// but the page is malformed.
+func DecodeSearchPageChecked(b []byte) (*SearchPage, error) {
+	if len(b) < flatbuffers.SizeUOffsetT+checksumLength {
+		return nil, fmt.Errorf("error decoding search page: too short: %d bytes", len(b))
+	}
+
+	page, trailer := b[:len(b)-checksumLength], b[len(b)-checksumLength:]
+	expected := binary.LittleEndian.Uint32(trailer)
+	if actual := crc32.Checksum(page, castagnoli); actual != expected {
+		return nil, fmt.Errorf("error decoding search page: %w: expected %08x, got %08x", ErrChecksumMismatch, expected, actual)
+	}
+
+	p, err := NewSearchPageFromBytesSafe(page)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding search page: %w", err)
+	}
+	return p, nil
+}
@@ -0,0 +1,42 @@
+package tempofb
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFinishWithChecksum(t *testing.T) {
+	b := NewSearchPageBuilder()
+	for i := 0; i < 10; i++ {
+		e := &SearchEntryMutable{TraceID: []byte{byte(i)}}
+		e.AddTag("service.name", "my-service")
+		b.AddData(e)
+	}
+	buf := b.FinishWithChecksum()
+
+	page, err := DecodeSearchPageChecked(buf)
+	require.NoError(t, err)
+	require.Equal(t, 10, page.EntriesLength())
+	require.True(t, PageContains(page, []byte("service.name"), []byte("my-service"), &KeyValues{}))
+
+	for _, i := range []int{0, len(buf) / 2, len(buf) - 1} {
+		corrupted := append([]byte(nil), buf...)
+		corrupted[i] ^= 0x01
+
+		_, err = DecodeSearchPageChecked(corrupted)
+		require.ErrorIs(t, err, ErrChecksumMismatch)
+	}
+
+	_, err = DecodeSearchPageChecked(buf[:5])
+	require.Error(t, err)
+
+	// Malformed page with a valid checksum
+	malformed := []byte{0xFF, 0xFF, 0xFF, 0x7F, 0, 0, 0, 0}
+	binary.LittleEndian.PutUint32(malformed[4:], crc32.Checksum(malformed[:4], castagnoli))
+	_, err = DecodeSearchPageChecked(malformed)
+	require.Error(t, err)
+	require.NotErrorIs(t, err, ErrChecksumMismatch)
+}
@@ -0,0 +1,151 @@
+package tempofb
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
+)
+
+// CodecID identifies the codec of a compressed search page. It is written as the first byte of the page.
+type CodecID byte
+
+const (
+	CodecIdentity CodecID = iota
+	CodecSnappy
+	CodecZstd
+)
+
+func (c CodecID) String() string {
+	switch c {
+	case CodecIdentity:
+		return "identity"
+	case CodecSnappy:
+		return "snappy"
+	case CodecZstd:
+		return "zstd"
+	}
+	return fmt.Sprintf("codec(%d)", byte(c))
+}
+
+// Codec compresses and decompresses search pages.
+type Codec interface {
+	ID() CodecID
+	Encode(src []byte) []byte
+	Decode(src []byte) ([]byte, error)
+}
+
+var codecs = map[CodecID]Codec{
+	CodecIdentity: IdentityCodec{},
+	CodecSnappy:   SnappyCodec{},
+	CodecZstd:     ZstdCodec{},
+}
+
+// IdentityCodec stores pages uncompressed.
+type IdentityCodec struct{}
+
+func (IdentityCodec) ID() CodecID                       { return CodecIdentity }
+func (IdentityCodec) Encode(src []byte) []byte          { return src }
+func (IdentityCodec) Decode(src []byte) ([]byte, error) { return src, nil }
+
+type SnappyCodec struct{}
+
+func (SnappyCodec) ID() CodecID { return CodecSnappy }
+
+func (SnappyCodec) Encode(src []byte) []byte {
+	return snappy.Encode(nil, src)
+}
+
+func (SnappyCodec) Decode(src []byte) ([]byte, error) {
+	return snappy.Decode(nil, src)
+}
+
+// ZstdCodec shares a single encoder and decoder which are created on first use and safe
+// for concurrent use.
+type ZstdCodec struct{}
+
+var (
+	zstdOnce    sync.Once
+	zstdEncoder *zstd.Encoder
+	zstdDecoder *zstd.Decoder
+	zstdErr     error
+)
+
+func initZstd() error {
+	zstdOnce.Do(func() {
+		zstdEncoder, zstdErr = zstd.NewWriter(nil)
+		if zstdErr != nil {
+			return
+		}
+		zstdDecoder, zstdErr = zstd.NewReader(nil)
+	})
+	return zstdErr
+}
+
+func (ZstdCodec) ID() CodecID { return CodecZstd }
+
+func (ZstdCodec) Encode(src []byte) []byte {
+	// Default options never fail
+	if err := initZstd(); err != nil {
+		panic(err)
+	}
+	return zstdEncoder.EncodeAll(src, nil)
+}
+
+func (ZstdCodec) Decode(src []byte) ([]byte, error) {
+	if err := initZstd(); err != nil {
+		return nil, err
+	}
+	return zstdDecoder.DecodeAll(src, nil)
+}
+
+// FinishCompressed is like Finish but compresses the page with the codec. The result is
+// prefixed with the codec ID and must be read with DecodeSearchPage.
+func (b *SearchPageBuilder) FinishCompressed(codec Codec) []byte {
+	encoded := codec.Encode(b.Finish())
+
+	buf := make([]byte, 1+len(encoded))
+	buf[0] = byte(codec.ID())
+	copy(buf[1:], encoded)
+	return buf
+}
+
+// DecodeSearchPage decompresses and decodes a page written by FinishCompressed. Returns an error if
+// the page is malformed.
+func DecodeSearchPage(b []byte) (*SearchPage, error) {
+	if len(b) < 1 {
+		return nil, fmt.Errorf("error decoding search page: empty")
+	}
+
+	codec, ok := codecs[CodecID(b[0])]
+	if !ok {
+		return nil, fmt.Errorf("error decoding search page: unknown codec %d", b[0])
+	}
+
+	buf, err := codec.Decode(b[1:])
+	if err != nil {
+		return nil, fmt.Errorf("error decoding search page: %w", err)
+	}
+
+	page, err := NewSearchPageFromBytesSafe(buf)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding search page: %w", err)
+	}
+	return page, nil
+}
+
+// EstimateCompression compresses the page with every codec supported by FinishCompressed and returns
+// the ratio of compressed to original size by codec name, e.g. 0.25 for a page compressed to a quarter.
+// It is a diagnostic to choose a codec and not meant for the hot path. Nil for an empty page.
+func EstimateCompression(b []byte) map[string]float64 {
+	if len(b) == 0 {
+		return nil
+	}
+
+	ratios := make(map[string]float64, len(codecs))
+	for id, codec := range codecs {
+		ratios[id.String()] = float64(len(codec.Encode(b))) / float64(len(b))
+	}
+	return ratios
+}
@@ -0,0 +1,67 @@
+package tempofb
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFinishCompressed(t *testing.T) {
+	for _, codec := range []Codec{IdentityCodec{}, SnappyCodec{}, ZstdCodec{}} {
+		t.Run(fmt.Sprintf("%T", codec), func(t *testing.T) {
+			b := NewSearchPageBuilder()
+			for i := 0; i < 100; i++ {
+				e := &SearchEntryMutable{TraceID: []byte{byte(i)}}
+				e.AddTag("service.name", "my-service")
+				b.AddData(e)
+			}
+
+			buf := b.FinishCompressed(codec)
+			require.Equal(t, byte(codec.ID()), buf[0])
+
+			page, err := DecodeSearchPage(buf)
+			require.NoError(t, err)
+			require.Equal(t, 100, page.EntriesLength())
+			require.True(t, PageContains(page, []byte("service.name"), []byte("my-service"), &KeyValues{}))
+		})
+	}
+}
+
+func TestDecodeSearchPageErrors(t *testing.T) {
+	_, err := DecodeSearchPage(nil)
+	require.Error(t, err)
+
+	_, err = DecodeSearchPage([]byte{0xFF, 0x00, 0x00, 0x00, 0x00})
+	require.Error(t, err)
+
+	_, err = DecodeSearchPage([]byte{byte(CodecSnappy), 0xFF, 0xFF})
+	require.Error(t, err)
+
+	_, err = DecodeSearchPage([]byte{byte(CodecIdentity), 0x00})
+	require.Error(t, err)
+
+	// Corrupt payload with an out of bounds root offset
+	_, err = DecodeSearchPage([]byte{byte(CodecIdentity), 0xFF, 0xFF, 0xFF, 0x7F})
+	require.Error(t, err)
+	_, err = DecodeSearchPage(append([]byte{byte(CodecSnappy)}, SnappyCodec{}.Encode([]byte{0xFF, 0xFF, 0xFF, 0x7F})...))
+	require.Error(t, err)
+}
+
+func TestEstimateCompression(t *testing.T) {
+	b := NewSearchPageBuilder()
+	for i := 0; i < 100; i++ {
+		s := &SearchEntryMutable{TraceID: []byte{byte(i)}}
+		s.AddTag("service.name", "checkout")
+		b.AddData(s)
+	}
+
+	ratios := EstimateCompression(b.Finish())
+	require.Len(t, ratios, 3)
+	require.Equal(t, 1.0, ratios["identity"])
+	require.Less(t, ratios["snappy"], 1.0)
+	require.Less(t, ratios["zstd"], 1.0)
+
+	require.Nil(t, EstimateCompression(nil))
+	require.Equal(t, "codec(9)", CodecID(9).String())
+}
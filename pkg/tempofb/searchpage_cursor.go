@@ -0,0 +1,33 @@
+package tempofb
+
+// EntryCursor reads entries of a page using its own buffers. The bytes of a page are never modified
+// and are safe to read concurrently, but SearchEntry and KeyValues objects are reusable buffers that
+// must not be shared between goroutines. Create one cursor per goroutine instead.
+type EntryCursor struct {
+	page  *SearchPage
+	entry SearchEntry
+	kv    KeyValues
+}
+
+func NewEntryCursor(page *SearchPage) *EntryCursor {
+	return &EntryCursor{
+		page: page,
+	}
+}
+
+// Len returns the number of entries in the page.
+func (c *EntryCursor) Len() int {
+	return c.page.EntriesLength()
+}
+
+// Entry positions the cursor at the i-th entry, in the order they were added to the builder, and
+// returns it. The entry is only valid until the next call.
+func (c *EntryCursor) Entry(i int) *SearchEntry {
+	entryAt(c.page, &c.entry, i)
+	return &c.entry
+}
+
+// Buffer returns the cursor's KeyValues buffer for use with lookups like Contains.
+func (c *EntryCursor) Buffer() *KeyValues {
+	return &c.kv
+}
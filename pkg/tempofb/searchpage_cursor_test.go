@@ -0,0 +1,64 @@
+package tempofb
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestEntryCursor(t *testing.T) {
+	b := NewSearchPageBuilder()
+	for i := 0; i < 3; i++ {
+		b.AddData(&SearchEntryMutable{TraceID: []byte{byte(i)}})
+	}
+
+	c := NewEntryCursor(GetRootAsSearchPage(b.Finish(), 0))
+	require.Equal(t, 3, c.Len())
+	for i := 0; i < c.Len(); i++ {
+		require.Equal(t, []byte{byte(i)}, c.Entry(i).Id())
+	}
+}
+
+func ExampleNewEntryCursor() {
+	b := NewSearchPageBuilder()
+	for i := 0; i < 1000; i++ {
+		e := &SearchEntryMutable{}
+		e.AddTag("foo", fmt.Sprintf("bar%d", i%10))
+		b.AddData(e)
+	}
+	page := GetRootAsSearchPage(b.Finish(), 0)
+
+	var (
+		workers = 4
+		next    = make(chan int)
+		matches int64
+		wg      sync.WaitGroup
+	)
+
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			// Each worker has its own cursor over the shared page
+			c := NewEntryCursor(page)
+			for i := range next {
+				if c.Entry(i).Contains([]byte("foo"), []byte("bar1"), c.Buffer()) {
+					atomic.AddInt64(&matches, 1)
+				}
+			}
+		}()
+	}
+
+	for i := 0; i < page.EntriesLength(); i++ {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+
+	fmt.Println(matches)
+	// Output: 100
+}
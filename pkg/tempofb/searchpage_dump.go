@@ -0,0 +1,59 @@
+package tempofb
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// DumpPage writes a human-readable form of the page to w. Each entry is written on its own line as:
+//
+//	<trace id> <start>-<end> key1=value1,value2 key2=value3
+//
+// followed by a line with the page-level tags. Keys and values are written in sorted order.
+func DumpPage(b []byte, w io.Writer) error {
+	page, err := NewSearchPageFromBytesSafe(b)
+	if err != nil {
+		return fmt.Errorf("error dumping search page: %w", err)
+	}
+
+	sb := &strings.Builder{}
+
+	ForeachEntry(page, func(e *SearchEntry) bool {
+		sb.Reset()
+		fmt.Fprintf(sb, "%s %d-%d", hex.EncodeToString(e.Id()), e.StartTimeUnixNano(), e.EndTimeUnixNano())
+		dumpTags(sb, e)
+		sb.WriteByte('\n')
+
+		_, err = io.WriteString(w, sb.String())
+		return err == nil
+	})
+	if err != nil {
+		return err
+	}
+
+	sb.Reset()
+	sb.WriteString("tags:")
+	dumpTags(sb, page)
+	sb.WriteByte('\n')
+	_, err = io.WriteString(w, sb.String())
+	return err
+}
+
+func dumpTags(sb *strings.Builder, s FBTagContainer) {
+	kv := &KeyValues{}
+	for i, l := 0, s.TagsLength(); i < l; i++ {
+		tagAt(s, kv, i)
+		sb.WriteByte(' ')
+		sb.Write(kv.Key())
+		sb.WriteByte('=')
+		rangeValues(kv, func(j int, v []byte) bool {
+			if j > 0 {
+				sb.WriteByte(',')
+			}
+			sb.Write(v)
+			return true
+		})
+	}
+}
@@ -0,0 +1,31 @@
+package tempofb
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDumpPage(t *testing.T) {
+	b := NewSearchPageBuilder()
+
+	e1 := &SearchEntryMutable{TraceID: []byte{0x01}, StartTimeUnixNano: 10, EndTimeUnixNano: 20}
+	e1.AddTag("key2", "b")
+	e1.AddTag("key1", "b")
+	e1.AddTag("key1", "a")
+	b.AddData(e1)
+
+	e2 := &SearchEntryMutable{TraceID: []byte{0x02}, StartTimeUnixNano: 30, EndTimeUnixNano: 40}
+	e2.AddTag("key1", "c")
+	b.AddData(e2)
+
+	buf := &bytes.Buffer{}
+	require.NoError(t, DumpPage(b.Finish(), buf))
+	require.Equal(t, "01 10-20 key1=a,b key2=b\n02 30-40 key1=c\ntags: key1=a,b,c key2=b\n", buf.String())
+}
+
+func TestDumpPageMalformed(t *testing.T) {
+	require.Error(t, DumpPage(nil, &bytes.Buffer{}))
+	require.Error(t, DumpPage([]byte{0xFF, 0xFF, 0xFF, 0x00, 0x01}, &bytes.Buffer{}))
+}
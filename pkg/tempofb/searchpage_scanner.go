@@ -0,0 +1,79 @@
+package tempofb
+
+import (
+	"bytes"
+	"unicode/utf8"
+)
+
+// SearchScanner scans pages without allocating by routing all lookups through its own SearchEntry
+// and KeyValues buffers, so callers don't have to thread buffers themselves. A scanner is not safe
+// for concurrent use, create one per goroutine.
+type SearchScanner struct {
+	entry SearchEntry
+	kv    KeyValues
+	key   []byte
+}
+
+func NewSearchScanner() *SearchScanner {
+	return &SearchScanner{}
+}
+
+// ForeachEntry invokes the callback for every entry of the page in the order they were added, until
+// the callback returns false. The entry is the scanner's buffer and is only valid during the callback.
+func (s *SearchScanner) ForeachEntry(page *SearchPage, fn func(e *SearchEntry) bool) {
+	for i, l := 0, page.EntriesLength(); i < l; i++ {
+		entryAt(page, &s.entry, i)
+		if !fn(&s.entry) {
+			return
+		}
+	}
+}
+
+// Get is like SearchEntry.Get but returns the value as a slice of the underlying buffer, or nil
+// if the key is not present. The key is lowercased into the scanner's buffer.
+func (s *SearchScanner) Get(e FBTagContainer, k string) []byte {
+	s.key = appendLower(s.key[:0], k)
+	kv := FindTag(e, &s.kv, s.key)
+	if kv != nil {
+		return kv.Value(0)
+	}
+
+	return nil
+}
+
+// Contains is like SearchEntry.Contains using the scanner's buffer. It also accepts a SearchPage
+// to check the page-level tags.
+func (s *SearchScanner) Contains(e FBTagContainer, k, v []byte) bool {
+	return ContainsTag(e, &s.kv, k, v)
+}
+
+// appendLower appends the lowercase form of s to dst.
+func appendLower(dst []byte, s string) []byte {
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c >= utf8.RuneSelf {
+			// Non-ASCII is rare in keys, fall back to the unicode aware version
+			return append(dst[:len(dst)-i], bytes.ToLower([]byte(s))...)
+		}
+		if 'A' <= c && c <= 'Z' {
+			c += 'a' - 'A'
+		}
+		dst = append(dst, c)
+	}
+	return dst
+}
+
+// appendLowerBytes is appendLower for byte slices.
+func appendLowerBytes(dst []byte, b []byte) []byte {
+	for i := 0; i < len(b); i++ {
+		c := b[i]
+		if c >= utf8.RuneSelf {
+			return append(dst[:len(dst)-i], bytes.ToLower(b)...)
+		}
+		if 'A' <= c && c <= 'Z' {
+			c += 'a' - 'A'
+		}
+		dst = append(dst, c)
+	}
+	return dst
+}
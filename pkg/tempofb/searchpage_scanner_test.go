@@ -0,0 +1,49 @@
+package tempofb
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSearchScanner(t *testing.T) {
+	b := NewSearchPageBuilder()
+	for i := 0; i < 10; i++ {
+		e := &SearchEntryMutable{TraceID: []byte{byte(i)}}
+		e.AddTag("service.name", fmt.Sprint("svc", i%2))
+		b.AddData(e)
+	}
+	page := GetRootAsSearchPage(b.Finish(), 0)
+
+	s := NewSearchScanner()
+	require.True(t, s.Contains(page, []byte("service.name"), []byte("svc1")))
+	require.False(t, s.Contains(page, []byte("service.name"), []byte("svc2")))
+
+	var ids []byte
+	s.ForeachEntry(page, func(e *SearchEntry) bool {
+		if s.Contains(e, []byte("service.name"), []byte("svc1")) {
+			ids = append(ids, e.Id()...)
+		}
+		require.Equal(t, e.Get("Service.Name"), string(s.Get(e, "Service.Name")))
+		require.Nil(t, s.Get(e, "missing"))
+		return true
+	})
+	require.Equal(t, []byte{1, 3, 5, 7, 9}, ids)
+
+	allocs := testing.AllocsPerRun(100, func() {
+		s.ForeachEntry(page, func(e *SearchEntry) bool {
+			s.Contains(e, []byte("service.name"), []byte("svc1"))
+			s.Get(e, "Service.Name")
+			return true
+		})
+	})
+	require.Zero(t, allocs)
+}
+
+func TestAppendLower(t *testing.T) {
+	for _, s := range []string{"", "abc", "Service.Name", "ÜBER.Key", "key.ÜBER"} {
+		require.Equal(t, string(bytes.ToLower([]byte(s))), string(appendLower([]byte("x"), s))[1:])
+	}
+}
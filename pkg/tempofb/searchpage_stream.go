@@ -0,0 +1,112 @@
+package tempofb
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	flatbuffers "github.com/google/flatbuffers/go"
+)
+
+// defaultMaxStreamedPageBytes is the largest page NewSearchPageStreamReader accepts. The length
+// prefix is untrusted, so it is checked before allocating the page.
+const defaultMaxStreamedPageBytes = 64 << 20
+
+// SearchPageStreamer writes search entries to an io.Writer as a sequence of pages, holding at most
+// one page in memory. Each page is prefixed with its length as a little-endian uint32.
+type SearchPageStreamer struct {
+	w       io.Writer
+	builder *SearchPageBuilder
+	header  [4]byte
+}
+
+// NewSearchPageStreamer returns a streamer that flushes a page once it reaches maxBytesPerPage. A page
+// is flushed after the entry that fills it, so pages can exceed the limit by up to one entry.
+func NewSearchPageStreamer(w io.Writer, maxBytesPerPage int) *SearchPageStreamer {
+	return &SearchPageStreamer{
+		w:       w,
+		builder: NewSearchPageBuilderWithLimit(maxBytesPerPage),
+	}
+}
+
+// Add writes the entry to the current page and flushes the page if it is full.
+func (s *SearchPageStreamer) Add(data *SearchEntryMutable) error {
+	s.builder.AddData(data)
+
+	if s.builder.Full() {
+		return s.flush()
+	}
+	return nil
+}
+
+// Close flushes the final partial page, if any. It does not close the underlying writer.
+func (s *SearchPageStreamer) Close() error {
+	if s.builder.EntryCount() == 0 {
+		return nil
+	}
+	return s.flush()
+}
+
+func (s *SearchPageStreamer) flush() error {
+	buf := s.builder.Finish()
+	defer s.builder.Reset()
+
+	binary.LittleEndian.PutUint32(s.header[:], uint32(len(buf)))
+	if _, err := s.w.Write(s.header[:]); err != nil {
+		return err
+	}
+
+	_, err := s.w.Write(buf)
+	return err
+}
+
+// SearchPageStreamReader reads the length-prefixed pages written by a SearchPageStreamer.
+type SearchPageStreamReader struct {
+	r            io.Reader
+	maxPageBytes int
+	header       [4]byte
+}
+
+// NewSearchPageStreamReader returns a reader which accepts pages of up to 64 MiB.
+func NewSearchPageStreamReader(r io.Reader) *SearchPageStreamReader {
+	return NewSearchPageStreamReaderWithLimit(r, defaultMaxStreamedPageBytes)
+}
+
+// NewSearchPageStreamReaderWithLimit returns a reader which rejects pages larger than maxPageBytes.
+func NewSearchPageStreamReaderWithLimit(r io.Reader, maxPageBytes int) *SearchPageStreamReader {
+	return &SearchPageStreamReader{
+		r:            r,
+		maxPageBytes: maxPageBytes,
+	}
+}
+
+// Next reads and returns the next page. Returns io.EOF when there are no more pages, and an error if
+// the length is out of bounds or the page is malformed.
+func (s *SearchPageStreamReader) Next() (*SearchPage, error) {
+	_, err := io.ReadFull(s.r, s.header[:])
+	if err == io.EOF {
+		return nil, io.EOF
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading search page length: %w", err)
+	}
+
+	l := binary.LittleEndian.Uint32(s.header[:])
+	if l < flatbuffers.SizeUOffsetT {
+		return nil, fmt.Errorf("error reading search page: page is too short: %d bytes", l)
+	}
+	if uint64(l) > uint64(s.maxPageBytes) {
+		return nil, fmt.Errorf("error reading search page: %d bytes exceeds limit of %d bytes", l, s.maxPageBytes)
+	}
+
+	buf := make([]byte, l)
+	if _, err = io.ReadFull(s.r, buf); err != nil {
+		return nil, fmt.Errorf("error reading search page: expected %d bytes: %w", l, err)
+	}
+
+	page, err := NewSearchPageFromBytesSafe(buf)
+	if err != nil {
+		return nil, fmt.Errorf("error reading search page: %w", err)
+	}
+	return page, nil
+}
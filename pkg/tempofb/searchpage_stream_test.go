@@ -0,0 +1,89 @@
+package tempofb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSearchPageStreamer(t *testing.T) {
+	buf := &bytes.Buffer{}
+	s := NewSearchPageStreamer(buf, 1024)
+
+	total := 100
+	for i := 0; i < total; i++ {
+		e := &SearchEntryMutable{TraceID: []byte{byte(i)}}
+		e.AddTag("key", fmt.Sprintf("value%d", i))
+		require.NoError(t, s.Add(e))
+	}
+	require.NoError(t, s.Close())
+
+	var ids []byte
+	pages := 0
+	r := NewSearchPageStreamReader(buf)
+	for {
+		page, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+
+		ForeachEntry(page, func(e *SearchEntry) bool {
+			ids = append(ids, e.Id()...)
+			return true
+		})
+		pages++
+	}
+
+	require.Greater(t, pages, 1)
+	require.Len(t, ids, total)
+	for i := range ids {
+		require.Equal(t, byte(i), ids[i])
+	}
+}
+
+func TestSearchPageStreamReaderTruncated(t *testing.T) {
+	buf := &bytes.Buffer{}
+	s := NewSearchPageStreamer(buf, 1024)
+	require.NoError(t, s.Add(&SearchEntryMutable{TraceID: []byte{1}}))
+	require.NoError(t, s.Close())
+	b := buf.Bytes()
+
+	_, err := NewSearchPageStreamReader(bytes.NewReader(nil)).Next()
+	require.Equal(t, io.EOF, err)
+
+	_, err = NewSearchPageStreamReader(bytes.NewReader(b[:2])).Next()
+	require.ErrorIs(t, err, io.ErrUnexpectedEOF)
+
+	_, err = NewSearchPageStreamReader(bytes.NewReader(b[:len(b)-1])).Next()
+	require.ErrorIs(t, err, io.ErrUnexpectedEOF)
+}
+
+func TestSearchPageStreamReaderInvalid(t *testing.T) {
+	frame := func(l uint32, payload []byte) io.Reader {
+		buf := make([]byte, 4, 4+len(payload))
+		binary.LittleEndian.PutUint32(buf, l)
+		return bytes.NewReader(append(buf, payload...))
+	}
+
+	// Too short for a root offset
+	for l := uint32(0); l < 4; l++ {
+		_, err := NewSearchPageStreamReader(frame(l, make([]byte, l))).Next()
+		require.Error(t, err)
+	}
+
+	// Oversized lengths are rejected before allocating
+	_, err := NewSearchPageStreamReader(frame(math.MaxUint32, nil)).Next()
+	require.EqualError(t, err, "error reading search page: 4294967295 bytes exceeds limit of 67108864 bytes")
+	_, err = NewSearchPageStreamReaderWithLimit(frame(100, make([]byte, 100)), 99).Next()
+	require.Error(t, err)
+
+	// Malformed page
+	_, err = NewSearchPageStreamReader(frame(4, []byte{0xFF, 0xFF, 0xFF, 0x7F})).Next()
+	require.Error(t, err)
+}
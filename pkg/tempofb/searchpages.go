@@ -0,0 +1,218 @@
+package tempofb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"github.com/grafana/tempo/tempodb/encoding/common"
+)
+
+// ErrScanLimitExceeded is returned by SearchPagesCtx when scanning the next page would exceed MaxBytesScanned.
+var ErrScanLimitExceeded = errors.New("search scan limit exceeded")
+
+// SearchPages returns the distinct trace IDs of up to limit entries across all pages which overlap
+// the time range [startNano, endNano] and match the predicate. Zero times and limit are unbounded,
+// see SearchEntry.Overlaps. Scanning stops as soon as the limit is reached. Returns an error if any
+// page is malformed.
+//
+// The predicate is opaque so pages can't be excluded by their page-level tags, use SearchPagesQuery
+// for that. A panic in the predicate is not recovered.
+func SearchPages(pages [][]byte, pred func(*SearchEntry) bool, startNano, endNano uint64, limit int) ([]common.ID, error) {
+	return SearchPagesCtx(context.Background(), pages, pred, SearchPagesOpts{
+		StartNano: startNano,
+		EndNano:   endNano,
+		Limit:     limit,
+	})
+}
+
+// SearchPagesOpts controls SearchPagesCtx. The zero value searches all pages without limits.
+type SearchPagesOpts struct {
+	// StartNano and EndNano are the time range entries must overlap, zero is unbounded. See
+	// SearchEntry.Overlaps.
+	StartNano uint64
+	EndNano   uint64
+
+	// Limit is the maximum number of trace IDs returned, zero is unlimited.
+	Limit int
+
+	// MaxBytesScanned limits the total length of the pages scanned, zero is unlimited. A page which
+	// would exceed it isn't scanned and ErrScanLimitExceeded is returned together with the trace IDs
+	// found in the pages before it, so callers can decide whether to surface the partial results.
+	MaxBytesScanned int
+
+	// CheckInterval is the number of entries scanned between checks of the context, zero is the
+	// default. See ForeachEntryCtx.
+	CheckInterval int
+}
+
+// SearchPagesCtx is like SearchPages but stops with the context error once the context is done.
+// The context is checked between pages and every CheckInterval entries within a page.
+func SearchPagesCtx(ctx context.Context, pages [][]byte, pred func(*SearchEntry) bool, opts SearchPagesOpts) ([]common.ID, error) {
+	s := newPageSearcher(pred, opts.StartNano, opts.EndNano, opts.Limit)
+	s.ctx = ctx
+	s.checkInterval = opts.CheckInterval
+	return s.searchPages(pages, opts.MaxBytesScanned)
+}
+
+// SearchPagesQuery is like SearchPages but matches the entries with the compiled query, including its
+// time range. Pages whose page-level tags can't match the query are skipped without scanning their
+// entries, see CompiledQuery.MatchesPage.
+func SearchPagesQuery(pages [][]byte, q *CompiledQuery, limit int) ([]common.ID, error) {
+	s := newPageSearcher(nil, q.startNano, q.endNano, limit)
+	s.query = q
+	s.pred = func(e *SearchEntry) bool {
+		return q.matchesTags(e, &s.kv)
+	}
+	return s.searchPages(pages, 0)
+}
+
+// searchPages searches the pages until the limit is reached, see SearchPagesCtx.
+func (s *pageSearcher) searchPages(pages [][]byte, maxBytesScanned int) ([]common.ID, error) {
+	ctx := s.ctx
+	scanned := 0
+	for i, p := range pages {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		scanned += len(p)
+		if maxBytesScanned > 0 && scanned > maxBytesScanned {
+			return s.ids, ErrScanLimitExceeded
+		}
+		if err := s.search(p); err != nil {
+			return nil, fmt.Errorf("error searching page %d: %w", i, err)
+		}
+		if s.done() {
+			break
+		}
+	}
+	return s.ids, nil
+}
+
+// pageSearcher collects matching trace IDs across pages.
+type pageSearcher struct {
+	ctx           context.Context
+	checkInterval int
+	query         *CompiledQuery // excludes pages by their page-level tags if set
+	kv            KeyValues      // buffer for the query
+	pred          func(*SearchEntry) bool
+	startNano     uint64
+	endNano       uint64
+	limit         int
+	seen          map[string]struct{}
+	ids           []common.ID
+}
+
+func newPageSearcher(pred func(*SearchEntry) bool, startNano, endNano uint64, limit int) *pageSearcher {
+	return &pageSearcher{
+		ctx:       context.Background(),
+		pred:      pred,
+		startNano: startNano,
+		endNano:   endNano,
+		limit:     limit,
+		seen:      map[string]struct{}{},
+	}
+}
+
+func (s *pageSearcher) done() bool {
+	return s.limit > 0 && len(s.ids) >= s.limit
+}
+
+// search scans the entries of the page. Only decoding is guarded against malformed data, panics of
+// the predicate are not recovered so that bugs in the caller aren't reported as malformed pages.
+func (s *pageSearcher) search(p []byte) error {
+	page, err := newSearchPageHeaderSafe(p)
+	if err != nil {
+		return err
+	}
+
+	// Entries of pages which can't match aren't read at all
+	if s.query != nil && !s.query.MatchesPage(page, &s.kv) {
+		return nil
+	}
+	if err := validateEntries(page); err != nil {
+		return err
+	}
+
+	return ForeachEntryCtx(s.ctx, page, s.checkInterval, func(e *SearchEntry) bool {
+		if !e.Overlaps(s.startNano, s.endNano) || !s.pred(e) {
+			return true
+		}
+
+		// Converting to string copies the id out of the page buffer.
+		id := string(e.Id())
+		if _, ok := s.seen[id]; ok {
+			return true
+		}
+		s.seen[id] = struct{}{}
+
+		s.ids = append(s.ids, common.ID(id))
+		return !s.done()
+	})
+}
+
+// SearchPagesParallel is like SearchPages but searches up to concurrency pages at the same time.
+// The predicate must be safe for concurrent use, the entry passed to it belongs to the calling
+// goroutine. The order of the results is not deterministic, and when the limit is reached it
+// depends on which pages were searched first. Returns the first error of any page.
+func SearchPagesParallel(pages [][]byte, concurrency int, pred func(*SearchEntry) bool, startNano, endNano uint64, limit int) ([]common.ID, error) {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	var (
+		next = make(chan int)
+		wg   sync.WaitGroup
+
+		mtx     sync.Mutex
+		results = newPageSearcher(pred, startNano, endNano, limit)
+		err     error
+		stop    int32
+	)
+
+	for w := 0; w < concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				// Each page is searched with its own buffers and merged afterwards.
+				s := newPageSearcher(pred, startNano, endNano, limit)
+				pageErr := s.search(pages[i])
+
+				mtx.Lock()
+				if pageErr != nil && err == nil {
+					err = fmt.Errorf("error searching page %d: %w", i, pageErr)
+				}
+				for _, id := range s.ids {
+					if results.done() {
+						break
+					}
+					if _, ok := results.seen[string(id)]; !ok {
+						results.seen[string(id)] = struct{}{}
+						results.ids = append(results.ids, id)
+					}
+				}
+				if err != nil || results.done() {
+					atomic.StoreInt32(&stop, 1)
+				}
+				mtx.Unlock()
+			}
+		}()
+	}
+
+	for i := range pages {
+		if atomic.LoadInt32(&stop) == 1 {
+			break
+		}
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+
+	if err != nil {
+		return nil, err
+	}
+	return results.ids, nil
+}
@@ -0,0 +1,238 @@
+package tempofb
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/grafana/tempo/tempodb/encoding/common"
+	"github.com/stretchr/testify/require"
+)
+
+// testPages returns pages with entries for traces 0-9 in each, every trace spanning [i*10, i*10+5].
+func testPages(pages int) [][]byte {
+	var out [][]byte
+	for p := 0; p < pages; p++ {
+		b := NewSearchPageBuilder()
+		for i := 0; i < 10; i++ {
+			e := &SearchEntryMutable{TraceID: []byte{byte(i)}, StartTimeUnixNano: uint64(i * 10), EndTimeUnixNano: uint64(i*10 + 5)}
+			e.AddTag("service.name", fmt.Sprint("svc", i%2))
+			b.AddData(e)
+		}
+		out = append(out, b.Finish())
+	}
+	return out
+}
+
+func TestSearchPages(t *testing.T) {
+	pages := testPages(3)
+	kv := &KeyValues{}
+	pred := func(e *SearchEntry) bool {
+		return e.Contains([]byte("service.name"), []byte("svc1"), kv)
+	}
+
+	ids, err := SearchPages(pages, pred, 0, 0, 0)
+	require.NoError(t, err)
+	require.Equal(t, []common.ID{{1}, {3}, {5}, {7}, {9}}, ids)
+
+	// Time range
+	ids, err = SearchPages(pages, pred, 30, 52, 0)
+	require.NoError(t, err)
+	require.Equal(t, []common.ID{{3}, {5}}, ids)
+
+	// Limit
+	ids, err = SearchPages(pages, pred, 0, 0, 2)
+	require.NoError(t, err)
+	require.Equal(t, []common.ID{{1}, {3}}, ids)
+
+	// Stops before the malformed page once the limit is reached
+	_, err = SearchPages(append(pages[:1:1], []byte{0x01}), pred, 0, 0, 2)
+	require.NoError(t, err)
+
+	_, err = SearchPages(append(pages[:1:1], []byte{0x01}), pred, 0, 0, 0)
+	require.Error(t, err)
+
+	_, err = SearchPages([][]byte{{0xFF, 0xFF, 0xFF, 0xFF, 0x00}}, pred, 0, 0, 0)
+	require.Error(t, err)
+}
+
+func TestSearchPagesPredicatePanic(t *testing.T) {
+	// Panics in the predicate are bugs of the caller, not malformed pages
+	require.PanicsWithValue(t, "pred", func() {
+		_, _ = SearchPages(testPages(1), func(*SearchEntry) bool { panic("pred") }, 0, 0, 0)
+	})
+}
+
+func TestSearchPagesQuery(t *testing.T) {
+	b := NewSearchPageBuilder()
+	e := &SearchEntryMutable{TraceID: []byte{100}, StartTimeUnixNano: 10, EndTimeUnixNano: 15}
+	e.AddTag("service.name", "other")
+	b.AddData(e)
+	pages := append([][]byte{b.Finish()}, testPages(2)...)
+
+	ids, err := SearchPagesQuery(pages, NewCompiledQuery(map[string][]string{"service.name": {"svc1"}}, 0, 0), 0)
+	require.NoError(t, err)
+	require.Equal(t, []common.ID{{1}, {3}, {5}, {7}, {9}}, ids)
+
+	ids, err = SearchPagesQuery(pages, NewCompiledQuery(map[string][]string{"service.name": {"svc1", "other"}}, 30, 52), 0)
+	require.NoError(t, err)
+	require.Equal(t, []common.ID{{3}, {5}}, ids)
+
+	ids, err = SearchPagesQuery(pages, NewCompiledQuery(map[string][]string{"service.name": {"other"}}, 0, 0), 1)
+	require.NoError(t, err)
+	require.Equal(t, []common.ID{{100}}, ids)
+
+	// Pages which can't match are skipped
+	ids, err = SearchPagesQuery(pages, NewCompiledQuery(map[string][]string{"service.name": {"nope"}}, 0, 0), 0)
+	require.NoError(t, err)
+	require.Empty(t, ids)
+
+	_, err = SearchPagesQuery(append(pages, []byte{0x01}), NewCompiledQuery(nil, 0, 0), 0)
+	require.Error(t, err)
+
+	// Entries of skipped pages aren't read, so their malformed tags are only found if the page matches
+	corrupt := append([]byte(nil), pages[0]...)
+	entry := &SearchEntry{}
+	GetRootAsSearchPage(corrupt, 0).Entries(entry, 0)
+	corruptVectorLength(entry._tab, 6)
+	pages[0] = corrupt
+
+	ids, err = SearchPagesQuery(pages, NewCompiledQuery(map[string][]string{"service.name": {"svc1"}}, 0, 0), 0)
+	require.NoError(t, err)
+	require.Equal(t, []common.ID{{1}, {3}, {5}, {7}, {9}}, ids)
+
+	_, err = SearchPagesQuery(pages, NewCompiledQuery(map[string][]string{"service.name": {"other"}}, 0, 0), 0)
+	require.Error(t, err)
+}
+
+func TestSearchPagesParallel(t *testing.T) {
+	pages := testPages(10)
+	pred := func(e *SearchEntry) bool {
+		// Buffer is allocated here so the predicate can be used concurrently.
+		return e.Contains([]byte("service.name"), []byte("svc1"), &KeyValues{})
+	}
+
+	for _, concurrency := range []int{0, 1, 4, 20} {
+		t.Run(fmt.Sprint(concurrency), func(t *testing.T) {
+			ids, err := SearchPagesParallel(pages, concurrency, pred, 0, 0, 0)
+			require.NoError(t, err)
+			require.ElementsMatch(t, []common.ID{{1}, {3}, {5}, {7}, {9}}, ids)
+
+			ids, err = SearchPagesParallel(pages, concurrency, pred, 30, 52, 0)
+			require.NoError(t, err)
+			require.ElementsMatch(t, []common.ID{{3}, {5}}, ids)
+
+			ids, err = SearchPagesParallel(pages, concurrency, pred, 0, 0, 2)
+			require.NoError(t, err)
+			require.Len(t, ids, 2)
+
+			_, err = SearchPagesParallel(append(pages[:5:5], []byte{0x01}), concurrency, pred, 0, 0, 0)
+			require.Error(t, err)
+		})
+	}
+}
+
+func BenchmarkSearchPages(b *testing.B) {
+	var pages [][]byte
+	for p := 0; p < 100; p++ {
+		sb := NewSearchPageBuilder()
+		for i := 0; i < 1000; i++ {
+			e := &SearchEntryMutable{TraceID: []byte(fmt.Sprintf("%08d%08d", p, i))}
+			e.AddTag("service.name", fmt.Sprint("svc", i%10))
+			e.AddTag("http.url", fmt.Sprint("/api/v1/", i))
+			e.AddTag("http.status_code", fmt.Sprint(200+i%5*100))
+			sb.AddData(e)
+		}
+		pages = append(pages, sb.Finish())
+	}
+
+	pred := func(e *SearchEntry) bool {
+		return e.ContainsExact([]byte("http.status_code"), []byte("500"), &KeyValues{})
+	}
+
+	b.Run("sequential", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			_, _ = SearchPages(pages, pred, 0, 0, 0)
+		}
+	})
+
+	b.Run("parallel", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			_, _ = SearchPagesParallel(pages, 4, pred, 0, 0, 0)
+		}
+	})
+}
+
+func TestSearchPagesCtx(t *testing.T) {
+	pages := testPages(3)
+	all := func(*SearchEntry) bool { return true }
+
+	ids, err := SearchPagesCtx(context.Background(), pages, all, SearchPagesOpts{})
+	require.NoError(t, err)
+	require.Len(t, ids, 10)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = SearchPagesCtx(ctx, pages, all, SearchPagesOpts{})
+	require.Equal(t, context.Canceled, err)
+
+	// Cancelled while scanning a page
+	ctx, cancel = context.WithCancel(context.Background())
+	scanned := 0
+	_, err = SearchPagesCtx(ctx, pages, func(*SearchEntry) bool {
+		scanned++
+		if scanned == 3 {
+			cancel()
+		}
+		return false
+	}, SearchPagesOpts{CheckInterval: 2})
+	require.ErrorIs(t, err, context.Canceled)
+	require.Equal(t, 4, scanned)
+}
+
+func TestForeachEntryCtx(t *testing.T) {
+	page := GetRootAsSearchPage(testPages(1)[0], 0)
+
+	var ids []byte
+	err := ForeachEntryCtx(context.Background(), page, 0, func(e *SearchEntry) bool {
+		ids = append(ids, e.Id()...)
+		return len(ids) < 5
+	})
+	require.NoError(t, err)
+	require.Equal(t, []byte{0, 1, 2, 3, 4}, ids)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = ForeachEntryCtx(ctx, page, 0, func(e *SearchEntry) bool {
+		require.Fail(t, "unexpected entry")
+		return true
+	})
+	require.Equal(t, context.Canceled, err)
+}
+
+func TestSearchPagesScanLimit(t *testing.T) {
+	pages := testPages(3)
+	size := len(pages[0])
+	kv := &KeyValues{}
+	pred := func(e *SearchEntry) bool {
+		return e.Contains([]byte("service.name"), []byte("svc1"), kv)
+	}
+
+	ids, err := SearchPagesCtx(context.Background(), pages, pred, SearchPagesOpts{MaxBytesScanned: 3 * size})
+	require.NoError(t, err)
+	require.Len(t, ids, 5)
+
+	// Partial results of the pages within the limit
+	ids, err = SearchPagesCtx(context.Background(), pages, pred, SearchPagesOpts{EndNano: 50, MaxBytesScanned: 3*size - 1})
+	require.Equal(t, ErrScanLimitExceeded, err)
+	require.Equal(t, []common.ID{{1}, {3}, {5}}, ids)
+
+	ids, err = SearchPagesCtx(context.Background(), pages, pred, SearchPagesOpts{MaxBytesScanned: size - 1})
+	require.Equal(t, ErrScanLimitExceeded, err)
+	require.Empty(t, ids)
+
+	// Not exceeded when the limit is reached before the remaining pages
+	ids, err = SearchPagesCtx(context.Background(), pages, pred, SearchPagesOpts{Limit: 2, MaxBytesScanned: size})
+	require.NoError(t, err)
+	require.Len(t, ids, 2)
+}
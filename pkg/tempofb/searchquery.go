@@ -0,0 +1,96 @@
+package tempofb
+
+import (
+	"bytes"
+	"sort"
+	"strings"
+)
+
+// CompiledQuery is a set of tag predicates and a time range prepared once and matched against many
+// entries, e.g. of thousands of pages. An entry matches if it overlaps the time range and, for every
+// key of the query, any of its values contains any of the query values as a substring, like
+// ContainsAnyValue. A key without values, or with an empty value, only has to exist.
+//
+// A CompiledQuery is immutable and safe for concurrent use, provided each goroutine passes its own
+// KeyValues buffer.
+type CompiledQuery struct {
+	terms     []queryTerm // sorted by key
+	startNano uint64
+	endNano   uint64
+}
+
+type queryTerm struct {
+	key    []byte
+	values [][]byte // sorted and deduped
+}
+
+// NewCompiledQuery compiles the map of tag keys to values, and the time range with the same zero
+// value semantics as SearchEntry.Overlaps. Keys and values are lowercased to match the written data,
+// keys which only differ in case are merged.
+func NewCompiledQuery(tags map[string][]string, startNano, endNano uint64) *CompiledQuery {
+	values := map[string]map[string]struct{}{}
+	for k, vs := range tags {
+		k = strings.ToLower(k)
+		set, ok := values[k]
+		if !ok {
+			set = map[string]struct{}{}
+			values[k] = set
+		}
+		for _, v := range vs {
+			set[strings.ToLower(v)] = struct{}{}
+		}
+	}
+
+	q := &CompiledQuery{
+		terms:     make([]queryTerm, 0, len(values)),
+		startNano: startNano,
+		endNano:   endNano,
+	}
+	for k, set := range values {
+		t := queryTerm{key: []byte(k)}
+		if _, ok := set[""]; ok || len(set) == 0 {
+			// The empty value matches everything else of the key
+			t.values = [][]byte{{}}
+		} else {
+			for v := range set {
+				t.values = append(t.values, []byte(v))
+			}
+			sort.Slice(t.values, func(i, j int) bool {
+				return bytes.Compare(t.values[i], t.values[j]) < 0
+			})
+		}
+		q.terms = append(q.terms, t)
+	}
+	sort.Slice(q.terms, func(i, j int) bool {
+		return bytes.Compare(q.terms[i].key, q.terms[j].key) < 0
+	})
+
+	return q
+}
+
+// Matches returns true if the entry matches the query. An empty query matches every entry in the
+// time range.
+func (q *CompiledQuery) Matches(e *SearchEntry, buffer *KeyValues) bool {
+	if !e.Overlaps(q.startNano, q.endNano) {
+		return false
+	}
+	return q.matchesTags(e, buffer)
+}
+
+// MatchesPage checks the page-level tags like PageContains. When false none of the entries in the
+// page can match and the page can be skipped. Always true for pages without page-level tags.
+func (q *CompiledQuery) MatchesPage(page *SearchPage, buffer *KeyValues) bool {
+	if !page.HasTags() {
+		return true
+	}
+	return q.matchesTags(page, buffer)
+}
+
+func (q *CompiledQuery) matchesTags(s FBTagContainer, buffer *KeyValues) bool {
+	for _, t := range q.terms {
+		if !ContainsTagAnyValue(s, buffer, t.key, t.values, false) {
+			return false
+		}
+	}
+	return true
+}
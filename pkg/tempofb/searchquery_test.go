@@ -0,0 +1,53 @@
+package tempofb
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompiledQuery(t *testing.T) {
+	s := &SearchEntryMutable{StartTimeUnixNano: 100, EndTimeUnixNano: 200}
+	s.AddTag("service.name", "checkout")
+	s.AddTag("http.status_code", "500")
+	e := GetRootAsSearchEntry(s.ToBytes(), 0)
+
+	b := NewSearchPageBuilder()
+	b.AddData(s)
+	page := GetRootAsSearchPage(b.Finish(), 0)
+
+	testCases := []struct {
+		name     string
+		tags     map[string][]string
+		start    uint64
+		end      uint64
+		expected bool
+	}{
+		{"empty", nil, 0, 0, true},
+		{"single", map[string][]string{"service.name": {"checkout"}}, 0, 0, true},
+		{"case", map[string][]string{"Service.Name": {"CHECK"}}, 0, 0, true},
+		{"any value", map[string][]string{"http.status_code": {"404", "500"}}, 0, 0, true},
+		{"all keys", map[string][]string{"service.name": {"checkout"}, "http.status_code": {"404"}}, 0, 0, false},
+		{"key exists", map[string][]string{"http.status_code": nil}, 0, 0, true},
+		{"empty value", map[string][]string{"http.status_code": {"404", ""}}, 0, 0, true},
+		{"missing key", map[string][]string{"foo": nil}, 0, 0, false},
+		{"in range", nil, 150, 300, true},
+		{"out of range", map[string][]string{"service.name": {"checkout"}}, 300, 400, false},
+	}
+
+	kv := &KeyValues{}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			q := NewCompiledQuery(tc.tags, tc.start, tc.end)
+			require.Equal(t, tc.expected, q.Matches(e, kv))
+
+			// The page can only be excluded by tags, not by time
+			if tc.expected {
+				require.True(t, q.MatchesPage(page, kv))
+			}
+		})
+	}
+
+	q := NewCompiledQuery(map[string][]string{"foo": nil}, 0, 0)
+	require.False(t, q.MatchesPage(page, kv))
+}